@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"rooms/dto"
+)
+
+// The admin methods need the token set with WithAdminToken.
+
+// Sessions lists the sessions connected to the server.
+func (a *Client) Sessions(ctx context.Context) (dto.SessionsResponse, error) {
+	res := dto.SessionsResponse{}
+	return res, a.get(ctx, "/admin/sessions", &res)
+}
+
+// Kick closes a session.
+func (a *Client) Kick(ctx context.Context, sessionId, reason string) (dto.ModerationResponse, error) {
+	return a.moderate(ctx, http.MethodPost, "/admin/sessions/"+url.PathEscape(sessionId)+"/kick", dto.ModerationRequest{Reason: reason})
+}
+
+func (a *Client) Bans(ctx context.Context) (dto.BansResponse, error) {
+	res := dto.BansResponse{}
+	return res, a.get(ctx, "/admin/bans", &res)
+}
+
+// Ban bans a player id or a nickname, kind being global.BanPlayer or
+// global.BanNickname, and closes the sessions of the banned players.
+func (a *Client) Ban(ctx context.Context, kind, value, reason string) (dto.ModerationResponse, error) {
+	return a.moderate(ctx, http.MethodPut, banPath(kind, value), dto.ModerationRequest{Reason: reason})
+}
+
+func (a *Client) Unban(ctx context.Context, kind, value string) error {
+	_, err := a.moderate(ctx, http.MethodDelete, banPath(kind, value), nil)
+	return err
+}
+
+// CloseRoom ends the game of a room without trophies.
+func (a *Client) CloseRoom(ctx context.Context, roomId, reason string) (dto.ModerationResponse, error) {
+	return a.moderate(ctx, http.MethodPost, "/admin/rooms/"+url.PathEscape(roomId)+"/close", dto.ModerationRequest{Reason: reason})
+}
+
+// ClearWaitingList takes every player off the waiting list.
+func (a *Client) ClearWaitingList(ctx context.Context) (dto.ModerationResponse, error) {
+	return a.moderate(ctx, http.MethodDelete, "/admin/waiting-list", nil)
+}
+
+// Announce sends a message to every connected session.
+func (a *Client) Announce(ctx context.Context, message string) (dto.ModerationResponse, error) {
+	return a.moderate(ctx, http.MethodPost, "/admin/announcements", dto.AnnouncementRequest{Message: message})
+}
+
+func (a *Client) moderate(ctx context.Context, method, path string, body any) (dto.ModerationResponse, error) {
+	res := dto.ModerationResponse{}
+	return res, a.do(ctx, method, path, body, http.StatusOK, &res)
+}
+
+func banPath(kind, value string) string {
+	return "/admin/bans/" + url.PathEscape(kind) + "/" + url.PathEscape(value)
+}
@@ -0,0 +1,631 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"rooms/dto"
+	"rooms/global"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDisconnected is returned for commands whose reply was lost because the
+// connection dropped. The command may or may not have been executed.
+var ErrDisconnected = errors.New("client: disconnected before the reply arrived")
+
+// ErrClosed is returned for commands sent after Close.
+var ErrClosed = errors.New("client: closed")
+
+// JoinedRoom is sent once the player was matched into a room.
+type JoinedRoom struct {
+	Room string
+}
+
+// GameOver is sent once the game of the player's room ended.
+type GameOver struct {
+	Secret   int
+	Rankings []dto.Ranking
+	Reason   string
+}
+
+// ServerShutdown is sent when the server starts draining.
+type ServerShutdown struct {
+	Deadline time.Time
+}
+
+// RoomClosed is sent when an operator closed the player's room.
+type RoomClosed struct {
+	Room   string
+	Reason string
+}
+
+// ChatMessage is a chat message of a player of the room, the client's own
+// messages included.
+type ChatMessage struct {
+	Room     string
+	Player   string
+	Nickname string
+	Message  string
+	Time     time.Time
+}
+
+// Announcement is a message of the operators.
+type Announcement struct {
+	Message string
+	Time    time.Time
+}
+
+// Client talks to a rooms server. Commands are correlated with their replies
+// by reqId, events are delivered on channels and dropped connections are
+// re-established with the player's waiting list and room state restored.
+type Client struct {
+	baseURL        string
+	httpClient     *http.Client
+	dialer         *websocket.Dialer
+	logger         *slog.Logger
+	reconnectMin   time.Duration
+	reconnectMax   time.Duration
+	joinedRoom     chan JoinedRoom
+	gameOver       chan GameOver
+	serverShutdown chan ServerShutdown
+	roomClosed     chan RoomClosed
+	announcement   chan Announcement
+	chatMessage    chan ChatMessage
+	adminToken     string
+	nextReq        atomic.Uint64
+
+	mutex        sync.Mutex
+	writeMutex   sync.Mutex
+	conn         *websocket.Conn
+	connected    chan struct{}
+	pending      map[string]chan dto.WebsocketCommandResponse
+	capabilities dto.HelloResponse
+	closed       bool
+	done         chan struct{}
+
+	// state restored after a reconnect
+	player  string
+	waiting bool
+	room    string
+	// time of the last chat message, older ones resent on resume are dropped
+	chatSeen time.Time
+}
+
+// New returns a client of the server at baseURL, for example
+// "http://localhost:8080".
+func New(baseURL string, options ...func(*Client)) *Client {
+	as := &Client{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		httpClient:     http.DefaultClient,
+		dialer:         websocket.DefaultDialer,
+		logger:         slog.Default(),
+		reconnectMin:   500 * time.Millisecond,
+		reconnectMax:   30 * time.Second,
+		joinedRoom:     make(chan JoinedRoom, 16),
+		gameOver:       make(chan GameOver, 16),
+		serverShutdown: make(chan ServerShutdown, 1),
+		roomClosed:     make(chan RoomClosed, 16),
+		announcement:   make(chan Announcement, 16),
+		chatMessage:    make(chan ChatMessage, 64),
+		connected:      make(chan struct{}),
+		pending:        map[string]chan dto.WebsocketCommandResponse{},
+		done:           make(chan struct{}),
+	}
+	for _, o := range options {
+		o(as)
+	}
+	return as
+}
+
+func WithHTTPClient(c *http.Client) func(*Client) {
+	return func(a *Client) {
+		a.httpClient = c
+	}
+}
+
+func WithDialer(d *websocket.Dialer) func(*Client) {
+	return func(a *Client) {
+		a.dialer = d
+	}
+}
+
+func WithLogger(l *slog.Logger) func(*Client) {
+	return func(a *Client) {
+		a.logger = l
+	}
+}
+
+// WithAdminToken sets the bearer token sent with the admin requests.
+func WithAdminToken(token string) func(*Client) {
+	return func(a *Client) {
+		a.adminToken = token
+	}
+}
+
+// WithReconnect sets the first and the longest wait between reconnect attempts.
+func WithReconnect(min, max time.Duration) func(*Client) {
+	return func(a *Client) {
+		a.reconnectMin = min
+		a.reconnectMax = max
+	}
+}
+
+func (a *Client) JoinedRoom() <-chan JoinedRoom {
+	return a.joinedRoom
+}
+
+func (a *Client) GameOver() <-chan GameOver {
+	return a.gameOver
+}
+
+func (a *Client) ServerShutdown() <-chan ServerShutdown {
+	return a.serverShutdown
+}
+
+func (a *Client) RoomClosed() <-chan RoomClosed {
+	return a.roomClosed
+}
+
+func (a *Client) Announcement() <-chan Announcement {
+	return a.announcement
+}
+
+func (a *Client) ChatMessage() <-chan ChatMessage {
+	return a.chatMessage
+}
+
+// Capabilities returns what the server announced in its hello reply.
+func (a *Client) Capabilities() dto.HelloResponse {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.capabilities
+}
+
+// Register registers a nickname and returns the player id.
+func (a *Client) Register(ctx context.Context, nickname string) (string, error) {
+	res := dto.RegisterResponse{}
+	err := a.do(ctx, http.MethodPost, "/register", dto.RegisterRequest{Nickname: nickname}, http.StatusCreated, &res)
+	return res.Id, err
+}
+
+// Stats returns the number of registered players and the active rooms.
+func (a *Client) Stats(ctx context.Context) (dto.StatsResponse, error) {
+	res := dto.StatsResponse{}
+	return res, a.get(ctx, "/stats", &res)
+}
+
+// RoomEvents returns the journal of a room.
+func (a *Client) RoomEvents(ctx context.Context, roomId string) (dto.RoomEventsResponse, error) {
+	res := dto.RoomEventsResponse{}
+	return res, a.get(ctx, "/rooms/"+url.PathEscape(roomId)+"/events", &res)
+}
+
+func (a *Client) get(ctx context.Context, path string, v any) error {
+	return a.do(ctx, http.MethodGet, path, nil, http.StatusOK, v)
+}
+
+// do sends body as JSON and decodes the response into v when it has the
+// expected status.
+func (a *Client) do(ctx context.Context, method, path string, body any, status int, v any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(a.adminToken) > 0 && strings.HasPrefix(path, "/admin/") {
+		req.Header.Set("Authorization", "Bearer "+a.adminToken)
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		return httpError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// httpError converts an error response of the server into a *global.Error.
+func httpError(resp *http.Response) error {
+	res := dto.Error{}
+	json.NewDecoder(resp.Body).Decode(&res)
+	if len(res.Message) == 0 {
+		res.Message = resp.Status
+	}
+	err := global.NewError(resp.StatusCode, res.Item, res.Message)
+	if seconds, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil {
+		return err.WithRetryAfter(time.Duration(seconds) * time.Second)
+	}
+	return err
+}
+
+// Connect opens the websocket and negotiates the protocol version. The
+// connection is kept open until Close.
+func (a *Client) Connect(ctx context.Context) error {
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return err
+	}
+	go a.run(conn)
+	return nil
+}
+
+// Close closes the connection and stops reconnecting.
+func (a *Client) Close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.done)
+	conn := a.conn
+	a.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	a.writeMutex.Lock()
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	a.writeMutex.Unlock()
+	return conn.Close()
+}
+
+func (a *Client) Join(ctx context.Context, id string) error {
+	reqId := a.reqId()
+	_, err := a.command(ctx, reqId, dto.JoinRequest{Cmd: "join", ReqId: reqId, Id: id})
+	if err == nil {
+		a.mutex.Lock()
+		a.player = id
+		a.waiting = true
+		a.mutex.Unlock()
+	}
+	return err
+}
+
+func (a *Client) Guess(ctx context.Context, id, roomId string, guess int) error {
+	reqId := a.reqId()
+	_, err := a.command(ctx, reqId, dto.GuessRequest{Cmd: "guess", ReqId: reqId, Id: id, RoomId: roomId, Data: &guess})
+	if err == nil {
+		a.mutex.Lock()
+		a.player = id
+		a.room = roomId
+		a.mutex.Unlock()
+	}
+	return err
+}
+
+// Chat sends a message to the players of the room.
+func (a *Client) Chat(ctx context.Context, id, roomId, message string) error {
+	reqId := a.reqId()
+	_, err := a.command(ctx, reqId, dto.ChatRequest{Cmd: "chat", ReqId: reqId, Id: id, RoomId: roomId, Message: message})
+	return err
+}
+
+// Resume binds the connection to the player's room after a reconnect. The
+// server sends the chat backlog of the room and the room's gameOver.
+func (a *Client) Resume(ctx context.Context, id, roomId string) error {
+	reqId := a.reqId()
+	_, err := a.command(ctx, reqId, dto.ResumeRequest{Cmd: "resume", ReqId: reqId, Id: id, RoomId: roomId})
+	return err
+}
+
+func (a *Client) Leave(ctx context.Context, id string) error {
+	reqId := a.reqId()
+	_, err := a.command(ctx, reqId, dto.LeaveRequest{Cmd: "leave", ReqId: reqId, Id: id})
+	if err == nil {
+		a.mutex.Lock()
+		a.waiting = false
+		a.mutex.Unlock()
+	}
+	return err
+}
+
+func (a *Client) reqId() string {
+	return "c" + strconv.FormatUint(a.nextReq.Add(1), 10)
+}
+
+// command sends v, waiting for a connection if the client is reconnecting, and
+// returns the reply correlated by reqId. Error replies are returned as
+// *global.Error.
+func (a *Client) command(ctx context.Context, reqId string, v any) (dto.WebsocketCommandResponse, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return dto.WebsocketCommandResponse{}, err
+	}
+	for {
+		a.mutex.Lock()
+		conn, connected, closed := a.conn, a.connected, a.closed
+		a.mutex.Unlock()
+		if closed {
+			return dto.WebsocketCommandResponse{}, ErrClosed
+		}
+		if conn != nil {
+			break
+		}
+		select {
+		case <-connected:
+		case <-a.done:
+		case <-ctx.Done():
+			return dto.WebsocketCommandResponse{}, ctx.Err()
+		}
+	}
+
+	replyC := make(chan dto.WebsocketCommandResponse, 1)
+	a.mutex.Lock()
+	conn := a.conn
+	a.pending[reqId] = replyC
+	a.mutex.Unlock()
+	defer func() {
+		a.mutex.Lock()
+		delete(a.pending, reqId)
+		a.mutex.Unlock()
+	}()
+	if conn == nil {
+		return dto.WebsocketCommandResponse{}, ErrDisconnected
+	}
+	a.writeMutex.Lock()
+	err = conn.WriteMessage(websocket.TextMessage, b)
+	a.writeMutex.Unlock()
+	if err != nil {
+		return dto.WebsocketCommandResponse{}, ErrDisconnected
+	}
+
+	select {
+	case res, ok := <-replyC:
+		if !ok {
+			return res, ErrDisconnected
+		}
+		if len(res.Error) > 0 {
+			return res, global.Kind(res.Error).
+				WithDetails(res.Details).
+				WithRetryAfter(time.Duration(res.RetryAfterMs) * time.Millisecond)
+		}
+		return res, nil
+	case <-ctx.Done():
+		return dto.WebsocketCommandResponse{}, ctx.Err()
+	}
+}
+
+// dial connects and sends hello. The connection is not yet used for commands.
+func (a *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := "ws" + strings.TrimPrefix(a.baseURL, "http") + "/websocket"
+	conn, resp, err := a.dialer.DialContext(ctx, u, http.Header{"Sec-WebSocket-Protocol": {"json"}})
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return nil, httpError(resp)
+		}
+		return nil, err
+	}
+	version := global.ProtocolVersion
+	b, _ := json.Marshal(dto.HelloRequest{Cmd: "hello", ReqId: a.reqId(), Version: &version})
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res := struct {
+		dto.WebsocketCommandResponse
+		Data dto.HelloResponse `json:"data"`
+	}{}
+	if err := conn.ReadJSON(&res); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if len(res.Error) > 0 {
+		conn.Close()
+		return nil, global.Kind(res.Error)
+	}
+	a.mutex.Lock()
+	a.capabilities = res.Data
+	a.mutex.Unlock()
+	return conn, nil
+}
+
+// run reads from conn until it drops, then reconnects with backoff until Close
+// or until the server kicks or bans the player.
+func (a *Client) run(conn *websocket.Conn) {
+	for {
+		a.attach(conn)
+		err := a.read(conn)
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) && (closeErr.Code == global.CloseKicked || closeErr.Code == global.CloseBanned) {
+			a.logger.Warn("client was closed by the server", "code", closeErr.Code, "reason", closeErr.Text)
+			a.Close()
+		}
+		if !a.detach() {
+			a.closeEvents()
+			return
+		}
+		conn = a.reconnect()
+		if conn == nil {
+			a.closeEvents()
+			return
+		}
+		a.logger.Info("client reconnected")
+		go a.restore()
+	}
+}
+
+func (a *Client) attach(conn *websocket.Conn) {
+	a.mutex.Lock()
+	a.conn = conn
+	close(a.connected)
+	a.mutex.Unlock()
+}
+
+// detach fails the pending commands of a dropped connection and reports
+// whether the client should reconnect.
+func (a *Client) detach() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.conn = nil
+	a.connected = make(chan struct{})
+	for reqId, replyC := range a.pending {
+		close(replyC)
+		delete(a.pending, reqId)
+	}
+	return !a.closed
+}
+
+func (a *Client) reconnect() *websocket.Conn {
+	wait := a.reconnectMin
+	for {
+		select {
+		case <-a.done:
+			return nil
+		case <-time.After(wait):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		conn, err := a.dial(ctx)
+		cancel()
+		if err == nil {
+			return conn
+		}
+		a.logger.Warn("client could not reconnect", "err", err, "wait", wait)
+		if wait *= 2; wait > a.reconnectMax {
+			wait = a.reconnectMax
+		}
+	}
+}
+
+// restore re-sends the commands the server forgot when the old connection was
+// torn down: a waiting player is put back on the waiting list and a player in
+// a running game resumes its room.
+func (a *Client) restore() {
+	a.mutex.Lock()
+	player, waiting, room := a.player, a.waiting, a.room
+	a.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var err error
+	switch {
+	case waiting:
+		err = a.Join(ctx, player)
+	case len(room) > 0:
+		err = a.Resume(ctx, player, room)
+	}
+	if err != nil {
+		a.logger.Warn("client could not restore its state", "player", player, "err", err)
+	}
+}
+
+// read dispatches the frames of conn until it fails and returns the error.
+func (a *Client) read(conn *websocket.Conn) error {
+	for {
+		frame := struct {
+			dto.WebsocketCommandResponse
+			dto.WebsocketEventResponse
+		}{}
+		if err := conn.ReadJSON(&frame); err != nil {
+			conn.Close()
+			return err
+		}
+		if len(frame.Event) > 0 {
+			a.event(frame.WebsocketEventResponse)
+			continue
+		}
+		a.mutex.Lock()
+		replyC, ok := a.pending[frame.ReqId]
+		delete(a.pending, frame.ReqId)
+		a.mutex.Unlock()
+		if ok {
+			replyC <- frame.WebsocketCommandResponse
+		}
+	}
+}
+
+func (a *Client) event(e dto.WebsocketEventResponse) {
+	switch e.Event {
+	case "joinedRoom":
+		a.mutex.Lock()
+		a.waiting = false
+		a.room = e.Room
+		a.chatSeen = time.Time{}
+		a.mutex.Unlock()
+		deliver(a, a.joinedRoom, JoinedRoom{Room: e.Room})
+	case "gameOver":
+		a.mutex.Lock()
+		a.room = ""
+		a.mutex.Unlock()
+		deliver(a, a.gameOver, GameOver{Secret: e.Secret, Rankings: e.Rankings, Reason: e.Reason})
+	case "serverShutdown":
+		event := ServerShutdown{}
+		if e.Deadline != nil {
+			event.Deadline = *e.Deadline
+		}
+		deliver(a, a.serverShutdown, event)
+	case "roomClosed":
+		a.mutex.Lock()
+		a.room = ""
+		a.mutex.Unlock()
+		deliver(a, a.roomClosed, RoomClosed{Room: e.Room, Reason: e.Reason})
+	case "chatMessage":
+		event := ChatMessage{Room: e.Room, Player: e.Player, Nickname: e.Nickname, Message: e.Message}
+		if e.Time != nil {
+			event.Time = *e.Time
+		}
+		a.mutex.Lock()
+		seen := !event.Time.After(a.chatSeen)
+		if !seen {
+			a.chatSeen = event.Time
+		}
+		a.mutex.Unlock()
+		if !seen {
+			deliver(a, a.chatMessage, event)
+		}
+	case "announcement":
+		event := Announcement{Message: e.Message}
+		if e.Time != nil {
+			event.Time = *e.Time
+		}
+		deliver(a, a.announcement, event)
+	default:
+		a.logger.Warn("client received an unknown event", "event", e.Event)
+	}
+}
+
+// deliver sends an event without blocking the read loop; events are dropped
+// when nobody drains the channel.
+func deliver[T any](a *Client, c chan T, v T) {
+	select {
+	case c <- v:
+	default:
+		a.logger.Warn("client event dropped, channel is full")
+	}
+}
+
+func (a *Client) closeEvents() {
+	close(a.joinedRoom)
+	close(a.gameOver)
+	close(a.serverShutdown)
+	close(a.roomClosed)
+	close(a.announcement)
+	close(a.chatMessage)
+}
@@ -0,0 +1,198 @@
+// Command loadgen plays games against a rooms server with many concurrent
+// virtual players and reports the latencies and errors they saw.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"rooms/client"
+	"rooms/global"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type options struct {
+	server   string
+	players  int
+	games    int
+	ramp     time.Duration
+	thinkMin time.Duration
+	thinkMax time.Duration
+	timeout  time.Duration
+	prefix   string
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "loadgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	o := options{}
+	flags := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	flags.StringVar(&o.server, "server", env("LOADGEN_SERVER", "http://localhost:8080"), "base URL of the rooms server")
+	flags.IntVar(&o.players, "players", 100, "number of virtual players")
+	flags.IntVar(&o.games, "games", 1, "games played by every player")
+	flags.DurationVar(&o.ramp, "ramp", 10*time.Second, "time over which the players are started")
+	flags.DurationVar(&o.thinkMin, "think-min", 0, "shortest think time before a join or a guess")
+	flags.DurationVar(&o.thinkMax, "think-max", time.Second, "longest think time before a join or a guess")
+	flags.DurationVar(&o.timeout, "timeout", 2*time.Minute, "longest wait for a joinedRoom or gameOver event")
+	flags.StringVar(&o.prefix, "prefix", "load-"+strconv.FormatInt(time.Now().Unix(), 36), "nickname prefix of the players")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if o.players < 1 || o.games < 1 || o.thinkMax < o.thinkMin {
+		return errors.New("players and games must be positive and think-max at least think-min")
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: o.players}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	r := newReport()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < o.players; i++ {
+		delay := o.ramp * time.Duration(i) / time.Duration(o.players)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if !sleep(ctx, delay) {
+				return
+			}
+			cl := client.New(o.server, client.WithHTTPClient(httpClient), client.WithLogger(logger))
+			defer cl.Close()
+			play(ctx, cl, fmt.Sprintf("%s-%d", o.prefix, i), o, r)
+		}(i)
+	}
+	wg.Wait()
+	r.write(out, o.players, o.players*o.games, time.Since(start))
+	return nil
+}
+
+// play registers a virtual player and plays its games. Errors are recorded and
+// end the player, except rate limits which are waited out.
+func play(ctx context.Context, cl *client.Client, nickname string, o options, r *report) {
+	var id string
+	err := retry(ctx, r, func() (err error) {
+		id, err = cl.Register(ctx, nickname)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if err := retry(ctx, r, func() error { return cl.Connect(ctx) }); err != nil {
+		return
+	}
+	rules := cl.Capabilities().Rules
+	for g := 0; g < o.games; g++ {
+		if !sleep(ctx, think(o)) {
+			return
+		}
+		started := time.Now()
+		if err := retry(ctx, r, func() error { return cl.Join(ctx, id) }); err != nil {
+			return
+		}
+		var room string
+		select {
+		case e, ok := <-cl.JoinedRoom():
+			if !ok {
+				r.fail(client.ErrClosed)
+				return
+			}
+			room = e.Room
+			r.joined(time.Since(started))
+		case <-time.After(o.timeout):
+			r.fail(context.DeadlineExceeded)
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		if !sleep(ctx, think(o)) {
+			return
+		}
+		guess := rand.Intn(rules.SecretMax-rules.SecretMin+1) + rules.SecretMin
+		started = time.Now()
+		if err := retry(ctx, r, func() error { return cl.Guess(ctx, id, room, guess) }); err != nil {
+			return
+		}
+		select {
+		case _, ok := <-cl.GameOver():
+			if !ok {
+				r.fail(client.ErrClosed)
+				return
+			}
+			r.gameOver(time.Since(started))
+		case <-time.After(o.timeout):
+			r.fail(context.DeadlineExceeded)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// retry calls f until it succeeds or fails with an error other than a rate
+// limit. Every error is recorded.
+func retry(ctx context.Context, r *report, f func() error) error {
+	for {
+		err := f()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		r.fail(err)
+		var e *global.Error
+		if !errors.As(err, &e) || !errors.Is(e, global.ErrRateLimited) {
+			return err
+		}
+		wait := e.RetryAfter()
+		if wait <= 0 {
+			wait = time.Second
+		}
+		if !sleep(ctx, wait) {
+			return ctx.Err()
+		}
+	}
+}
+
+func think(o options) time.Duration {
+	if o.thinkMax == o.thinkMin {
+		return o.thinkMin
+	}
+	return o.thinkMin + time.Duration(rand.Int63n(int64(o.thinkMax-o.thinkMin)))
+}
+
+// sleep waits for d and reports false if ctx was done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func env(key, def string) string {
+	if v := os.Getenv(key); len(v) > 0 {
+		return v
+	}
+	return def
+}
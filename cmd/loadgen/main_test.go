@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"rooms/handler"
+	"rooms/model"
+	"rooms/repo"
+	"rooms/service"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	Convey("Loadgen", t, func(c C) {
+		repo := repo.NewRepository(
+			repo.WithPlayers(map[string]*model.Player{}),
+			repo.WithRooms(map[string]*model.Room{}),
+			repo.WithWaitingList(map[string]*model.Player{}),
+			repo.WithJournal(map[string][]model.RoomEvent{}),
+		)
+		serv := service.NewService(service.WithRepo(repo))
+		handler := handler.NewHandler(
+			handler.WithService(serv),
+			handler.WithMatchmakingInterval(50*time.Millisecond),
+		)
+		router := mux.NewRouter()
+		router.Handle("/register", handler.Register()).Methods("POST")
+		router.Handle("/websocket", handler.Websocket())
+		s := httptest.NewServer(router)
+		defer s.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		Convey("Every game is played in a new room", func(c C) {
+			out := &bytes.Buffer{}
+			err := run(ctx, []string{"-server", s.URL, "-players", "3", "-games", "4", "-ramp", "0", "-think-max", "0", "-timeout", "10s"}, out)
+			c.So(err, ShouldBeNil)
+			c.So(out.String(), ShouldStartWith, "3 players, 12 of 12 games finished")
+			c.So(out.String(), ShouldEndWith, "\nno errors\n")
+
+			rooms := repo.GetRoomsSnapshot()
+			c.So(len(rooms), ShouldEqual, 4)
+			for _, room := range rooms {
+				c.So(serv.Finished(room.ID), ShouldBeTrue)
+			}
+		})
+
+		Convey("Invalid flags are rejected", func(c C) {
+			err := run(ctx, []string{"-server", s.URL, "-games", "0"}, &bytes.Buffer{})
+			c.So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"rooms/client"
+	"rooms/global"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// report collects the latencies and errors of all virtual players.
+type report struct {
+	mutex    sync.Mutex
+	joins    []time.Duration
+	games    []time.Duration
+	failures map[string]int
+}
+
+func newReport() *report {
+	return &report{failures: map[string]int{}}
+}
+
+func (a *report) joined(d time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.joins = append(a.joins, d)
+}
+
+func (a *report) gameOver(d time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.games = append(a.games, d)
+}
+
+func (a *report) fail(err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.failures[errorCode(err)]++
+}
+
+// errorCode is the error code sent by the server, or a name for errors that
+// happened before a reply arrived.
+func errorCode(err error) string {
+	var e *global.Error
+	switch {
+	case errors.As(err, &e):
+		return e.Code()
+	case errors.Is(err, context.DeadlineExceeded):
+		return "TIMEOUT"
+	case errors.Is(err, client.ErrDisconnected), errors.Is(err, client.ErrClosed):
+		return "DISCONNECTED"
+	case errors.Is(err, websocket.ErrBadHandshake):
+		return "BAD_HANDSHAKE"
+	default:
+		return "TRANSPORT"
+	}
+}
+
+func (a *report) write(out io.Writer, players, games int, elapsed time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	fmt.Fprintf(out, "%d players, %d of %d games finished in %s (%.1f games/s)\n\n",
+		players, len(a.games), games, elapsed.Round(time.Millisecond), float64(len(a.games))/elapsed.Seconds())
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LATENCY\tCOUNT\tP50\tP90\tP95\tP99\tMAX")
+	for _, l := range []struct {
+		name string
+		d    []time.Duration
+	}{
+		{"join→joinedRoom", a.joins},
+		{"guess→gameOver", a.games},
+	} {
+		sort.Slice(l.d, func(i, j int) bool { return l.d[i] < l.d[j] })
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", l.name, len(l.d),
+			percentile(l.d, 50), percentile(l.d, 90), percentile(l.d, 95), percentile(l.d, 99), percentile(l.d, 100))
+	}
+	w.Flush()
+
+	if len(a.failures) == 0 {
+		fmt.Fprintln(out, "\nno errors")
+		return
+	}
+	codes := make([]string, 0, len(a.failures))
+	for code := range a.failures {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ERROR\tCOUNT")
+	for _, code := range codes {
+		fmt.Fprintf(w, "%s\t%d\n", code, a.failures[code])
+	}
+	w.Flush()
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p int) string {
+	if len(sorted) == 0 {
+		return "-"
+	}
+	i := (p*len(sorted)+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Millisecond).String()
+}
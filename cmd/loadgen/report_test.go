@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"rooms/client"
+	"rooms/global"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	Convey("Percentile", t, func(c C) {
+		sorted := make([]time.Duration, 0, 100)
+		for i := 1; i <= 100; i++ {
+			sorted = append(sorted, time.Duration(i)*time.Millisecond)
+		}
+		for _, tc := range []struct {
+			d    []time.Duration
+			p    int
+			want string
+		}{
+			{nil, 50, "-"},
+			{sorted[:1], 50, "1ms"},
+			{sorted[:1], 100, "1ms"},
+			{sorted, 50, "50ms"},
+			{sorted, 99, "99ms"},
+			{sorted, 100, "100ms"},
+			{sorted[:10], 95, "10ms"},
+			{sorted[:10], 0, "1ms"},
+		} {
+			c.So(percentile(tc.d, tc.p), ShouldEqual, tc.want)
+		}
+	})
+}
+
+func TestErrorCode(t *testing.T) {
+	Convey("Error codes", t, func(c C) {
+		for _, tc := range []struct {
+			err  error
+			want string
+		}{
+			{global.ErrRateLimited.WithRetryAfter(time.Second), "RATE_LIMITED"},
+			{fmt.Errorf("join: %w", global.ErrNotRegistered), "NOT_REGISTERED"},
+			{context.DeadlineExceeded, "TIMEOUT"},
+			{client.ErrDisconnected, "DISCONNECTED"},
+			{client.ErrClosed, "DISCONNECTED"},
+			{fmt.Errorf("dial: %w", websocket.ErrBadHandshake), "BAD_HANDSHAKE"},
+			{errors.New("connection refused"), "TRANSPORT"},
+		} {
+			c.So(errorCode(tc.err), ShouldEqual, tc.want)
+		}
+	})
+}
+
+func TestReport(t *testing.T) {
+	Convey("Report", t, func(c C) {
+		r := newReport()
+		for _, ms := range []int{30, 10, 20} {
+			r.joined(time.Duration(ms) * time.Millisecond)
+		}
+		r.gameOver(time.Second)
+
+		Convey("Without errors", func(c C) {
+			out := &bytes.Buffer{}
+			r.write(out, 3, 3, 2*time.Second)
+			c.So(out.String(), ShouldStartWith, "3 players, 1 of 3 games finished in 2s (0.5 games/s)\n")
+			c.So(out.String(), ShouldContainSubstring, "join→joinedRoom  3      20ms  30ms  30ms  30ms  30ms")
+			c.So(out.String(), ShouldContainSubstring, "guess→gameOver   1      1s    1s    1s    1s    1s")
+			c.So(out.String(), ShouldEndWith, "\nno errors\n")
+		})
+
+		Convey("Errors are counted by code", func(c C) {
+			r.fail(global.ErrRateLimited)
+			r.fail(global.ErrRateLimited)
+			r.fail(context.DeadlineExceeded)
+			out := &bytes.Buffer{}
+			r.write(out, 3, 3, 2*time.Second)
+			c.So(out.String(), ShouldEndWith, "ERROR         COUNT\nRATE_LIMITED  2\nTIMEOUT       1\n")
+		})
+	})
+}
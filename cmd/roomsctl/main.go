@@ -0,0 +1,355 @@
+// Command roomsctl registers players, plays games from the terminal and
+// inspects a rooms server.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"rooms/client"
+	"rooms/dto"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: roomsctl [-server URL] <command> [arguments]
+
+Commands:
+  register <nickname>   register a player and print its id
+  join <id>             join the waiting list and wait for a room
+  play <id>             join, guess from the terminal and show the result;
+                        lines starting with /say are sent to the room chat
+  rooms                 list the active rooms
+  tail <room>           print the events of a room as they happen
+
+Admin commands, given -token:
+  sessions                         list the connected sessions
+  kick <session> [reason]          close a session
+  bans                             list the bans
+  ban player|nickname <value> [reason]
+                                   ban a player id or a nickname
+  unban player|nickname <value>    remove a ban
+  close <room> [reason]            end the game of a room without trophies
+  clear-waiting                    take every player off the waiting list
+  announce <message>               send a message to every session
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "roomsctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("roomsctl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	server := flags.String("server", env("ROOMSCTL_SERVER", "http://localhost:8080"), "base URL of the rooms server")
+	interval := flags.Duration("interval", time.Second, "poll interval of tail")
+	token := flags.String("token", env("ROOMSCTL_TOKEN", ""), "admin token of the server")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+	cl := client.New(*server,
+		client.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		client.WithAdminToken(*token),
+	)
+	defer cl.Close()
+
+	cmd, args := args[0], args[1:]
+	switch {
+	case cmd == "register" && len(args) == 1:
+		id, err := cl.Register(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, id)
+		return nil
+	case cmd == "join" && len(args) == 1:
+		room, err := join(ctx, cl, args[0], out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, room)
+		return nil
+	case cmd == "play" && len(args) == 1:
+		return play(ctx, cl, args[0], in, out)
+	case cmd == "rooms" && len(args) == 0:
+		return rooms(ctx, cl, out)
+	case cmd == "tail" && len(args) == 1:
+		return tail(ctx, cl, args[0], *interval, out)
+	case cmd == "sessions" && len(args) == 0:
+		return sessions(ctx, cl, out)
+	case cmd == "kick" && len(args) >= 1:
+		return moderated(out)(cl.Kick(ctx, args[0], strings.Join(args[1:], " ")))
+	case cmd == "bans" && len(args) == 0:
+		return bans(ctx, cl, out)
+	case cmd == "ban" && len(args) >= 2:
+		return moderated(out)(cl.Ban(ctx, args[0], args[1], strings.Join(args[2:], " ")))
+	case cmd == "unban" && len(args) == 2:
+		return cl.Unban(ctx, args[0], args[1])
+	case cmd == "close" && len(args) >= 1:
+		return moderated(out)(cl.CloseRoom(ctx, args[0], strings.Join(args[1:], " ")))
+	case cmd == "clear-waiting" && len(args) == 0:
+		return moderated(out)(cl.ClearWaitingList(ctx))
+	case cmd == "announce" && len(args) >= 1:
+		return moderated(out)(cl.Announce(ctx, strings.Join(args, " ")))
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown command or wrong arguments: %s", strings.Join(append([]string{cmd}, args...), " "))
+	}
+}
+
+// join puts the player on the waiting list and blocks until it is matched.
+func join(ctx context.Context, cl *client.Client, id string, out io.Writer) (string, error) {
+	if err := cl.Connect(ctx); err != nil {
+		return "", err
+	}
+	if err := cl.Join(ctx, id); err != nil {
+		return "", err
+	}
+	fmt.Fprintln(out, "waiting for a room...")
+	for {
+		select {
+		case e, ok := <-cl.JoinedRoom():
+			if !ok {
+				return "", errors.New("connection closed")
+			}
+			return e.Room, nil
+		case e, ok := <-cl.Announcement():
+			if ok {
+				fmt.Fprintln(out, "announcement:", e.Message)
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func play(ctx context.Context, cl *client.Client, id string, in io.Reader, out io.Writer) error {
+	room, err := join(ctx, cl, id, out)
+	if err != nil {
+		return err
+	}
+	rules := cl.Capabilities().Rules
+	// Chat messages are printed while the prompt waits for input.
+	out = &syncWriter{w: out}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case m, ok := <-cl.ChatMessage():
+				if !ok {
+					return
+				}
+				fmt.Fprintf(out, "\n%s: %s\n", m.Nickname, m.Message)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	fmt.Fprintf(out, "joined room %s\n", room)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(out, "your guess (%d-%d): ", rules.SecretMin, rules.SecretMax)
+		if !scanner.Scan() {
+			return errors.New("no guess given")
+		}
+		if message, ok := strings.CutPrefix(scanner.Text(), "/say "); ok {
+			if err := cl.Chat(ctx, id, room, message); err != nil {
+				fmt.Fprintln(out, "chat:", err)
+			}
+			continue
+		}
+		guess, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil || guess < rules.SecretMin || guess > rules.SecretMax {
+			fmt.Fprintln(out, "not a number in range")
+			continue
+		}
+		if err := cl.Guess(ctx, id, room, guess); err != nil {
+			return err
+		}
+		break
+	}
+	fmt.Fprintln(out, "waiting for the other players...")
+	for {
+		select {
+		case e, ok := <-cl.GameOver():
+			if !ok {
+				return errors.New("connection closed")
+			}
+			fmt.Fprintf(out, "secret was %d\n", e.Secret)
+			printRankings(out, e.Rankings)
+			return nil
+		case e, ok := <-cl.RoomClosed():
+			if ok {
+				fmt.Fprintln(out, "room closed by an operator:", e.Reason)
+			}
+			return nil
+		case e, ok := <-cl.Announcement():
+			if ok {
+				fmt.Fprintln(out, "announcement:", e.Message)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func rooms(ctx context.Context, cl *client.Client, out io.Writer) error {
+	stats, err := cl.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOM\tSECRET")
+	for _, r := range stats.ActiveRooms {
+		fmt.Fprintf(w, "%s\t%d\n", r.Id, r.Secret)
+	}
+	fmt.Fprintf(w, "\n%d registered players\n", stats.RegisteredPlayers)
+	return w.Flush()
+}
+
+func sessions(ctx context.Context, cl *client.Client, out io.Writer) error {
+	res, err := cl.Sessions(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tTRANSPORT\tCODEC\tPLAYER\tROOM")
+	for _, s := range res.Sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Id, s.Transport, s.Codec, s.Player, s.Room)
+	}
+	return w.Flush()
+}
+
+func bans(ctx context.Context, cl *client.Client, out io.Writer) error {
+	res, err := cl.Bans(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tVALUE\tSINCE\tREASON")
+	for _, b := range res.Bans {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.Kind, b.Value, b.Time.Format(time.RFC3339), b.Reason)
+	}
+	return w.Flush()
+}
+
+// moderated prints how many sessions and players an admin command reached.
+func moderated(out io.Writer) func(dto.ModerationResponse, error) error {
+	return func(res dto.ModerationResponse, err error) error {
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d sessions", res.Sessions)
+		if len(res.Players) > 0 {
+			fmt.Fprintf(out, ", players %s", strings.Join(res.Players, ","))
+		}
+		fmt.Fprintln(out)
+		return nil
+	}
+}
+
+// tail polls the journal of a room and prints every new event until the game
+// is over.
+func tail(ctx context.Context, cl *client.Client, roomId string, interval time.Duration, out io.Writer) error {
+	seq := 0
+	for {
+		res, err := cl.RoomEvents(ctx, roomId)
+		if err != nil {
+			return err
+		}
+		for _, e := range res.Events {
+			if e.Seq <= seq {
+				continue
+			}
+			seq = e.Seq
+			printEvent(out, e)
+			if e.Type == "gameOver" || e.Type == "roomClosed" {
+				return nil
+			}
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func printEvent(out io.Writer, e dto.RoomEvent) {
+	fmt.Fprintf(out, "%s #%d %s", e.Time.Format(time.RFC3339), e.Seq, e.Type)
+	switch {
+	case len(e.Players) > 0:
+		fmt.Fprintf(out, " players=%s", strings.Join(e.Players, ","))
+		if len(e.Bots) > 0 {
+			fmt.Fprintf(out, " bots=%s", strings.Join(e.Bots, ","))
+		}
+	case e.Guess != nil:
+		fmt.Fprintf(out, " player=%s guess=%d", e.Player, *e.Guess)
+	case len(e.Player) > 0:
+		fmt.Fprintf(out, " player=%s", e.Player)
+	case len(e.Reason) > 0:
+		fmt.Fprintf(out, " reason=%s", e.Reason)
+	}
+	fmt.Fprintln(out)
+	if len(e.Rankings) > 0 {
+		fmt.Fprintf(out, "  secret=%d\n", e.Secret)
+		printRankings(out, e.Rankings)
+	}
+}
+
+func printRankings(out io.Writer, rankings []dto.Ranking) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "  RANK\tPLAYER\tGUESS\tTROPHIES")
+	for _, r := range rankings {
+		player := r.Player
+		if r.Bot {
+			player += " (bot)"
+		}
+		fmt.Fprintf(w, "  %d\t%s\t%d\t%+d\n", r.Rank, player, r.Guess, r.DeltaTrophy)
+	}
+	w.Flush()
+}
+
+// syncWriter serializes the writes of concurrent goroutines to w.
+type syncWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.w.Write(p)
+}
+
+func env(key, def string) string {
+	if v := os.Getenv(key); len(v) > 0 {
+		return v
+	}
+	return def
+}
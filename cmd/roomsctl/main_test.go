@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"rooms/global"
+	"rooms/handler"
+	"rooms/model"
+	"rooms/repo"
+	"rooms/service"
+	"strings"
+	"testing"
+	"time"
+)
+
+// prepareServer serves players 1 to 6. Players 4, 5 and 6 play in room1,
+// players 2 and 3 are waiting for a room.
+func prepareServer(c C) (*httptest.Server, *service.Service, repo.Repo) {
+	players := map[string]*model.Player{}
+	for _, id := range []string{"1", "2", "3", "4", "5", "6"} {
+		players[id] = &model.Player{
+			ID:       id,
+			NickName: "n" + id,
+			Guess:    -1,
+		}
+	}
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{players["4"], players["5"], players["6"]},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{"2": players["2"], "3": players["3"]}),
+		repo.WithJournal(map[string][]model.RoomEvent{
+			"room1": {{Seq: 1, RoomID: "room1", Type: global.RoomCreated, Time: time.Now(), Players: []string{"4", "5", "6"}, Secret: 3}},
+		}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithAdminToken("secret"),
+		handler.WithMatchmakingInterval(50*time.Millisecond),
+	)
+	router := mux.NewRouter()
+	router.Handle("/register", handler.Register()).Methods("POST")
+	router.Handle("/stats", handler.Stats()).Methods("GET")
+	router.Handle("/rooms/{id}/events", handler.RoomEvents()).Methods("GET")
+	router.Handle("/websocket", handler.Websocket())
+	router.Handle("/admin/sessions", handler.Admin(handler.Sessions())).Methods("GET")
+	router.Handle("/admin/sessions/{id}/kick", handler.Admin(handler.Kick())).Methods("POST")
+	router.Handle("/admin/bans", handler.Admin(handler.Bans())).Methods("GET")
+	router.Handle("/admin/bans/{kind}/{value}", handler.Admin(handler.Ban())).Methods("PUT")
+	router.Handle("/admin/bans/{kind}/{value}", handler.Admin(handler.Unban())).Methods("DELETE")
+	router.Handle("/admin/rooms/{id}/close", handler.Admin(handler.CloseRoom())).Methods("POST")
+	router.Handle("/admin/waiting-list", handler.Admin(handler.ClearWaitingList())).Methods("DELETE")
+	router.Handle("/admin/announcements", handler.Admin(handler.Announce())).Methods("POST")
+	return httptest.NewServer(router), serv, repo
+}
+
+func TestRun(t *testing.T) {
+	Convey("Roomsctl", t, func(c C) {
+		s, serv, repo := prepareServer(c)
+		defer s.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		roomsctl := func(in string, args ...string) (string, error) {
+			out := &bytes.Buffer{}
+			err := run(ctx, append([]string{"-server", s.URL}, args...), strings.NewReader(in), out)
+			return out.String(), err
+		}
+
+		Convey("Flags and arguments are checked", func(c C) {
+			_, err := roomsctl("", "-bogus", "rooms")
+			c.So(err, ShouldNotBeNil)
+			_, err = roomsctl("")
+			c.So(err, ShouldBeError, "missing command")
+			_, err = roomsctl("", "register")
+			c.So(err, ShouldBeError, "unknown command or wrong arguments: register")
+			_, err = roomsctl("", "frobnicate", "now")
+			c.So(err, ShouldBeError, "unknown command or wrong arguments: frobnicate now")
+		})
+
+		Convey("Register prints the player id", func(c C) {
+			out, err := roomsctl("", "register", "d")
+			c.So(err, ShouldBeNil)
+			p, err := repo.GetPlayerByNickName("d")
+			c.So(err, ShouldBeNil)
+			c.So(out, ShouldEqual, p.ID+"\n")
+		})
+
+		Convey("Rooms lists the rooms", func(c C) {
+			out, err := roomsctl("", "rooms")
+			c.So(err, ShouldBeNil)
+			c.So(out, ShouldContainSubstring, "room1")
+			c.So(out, ShouldEndWith, "\n6 registered players\n")
+		})
+
+		Convey("Tail prints the journal until the game is over", func(c C) {
+			_, err := serv.ForceFinish("room1")
+			c.So(err, ShouldBeNil)
+			out, err := roomsctl("", "-interval", "10ms", "tail", "room1")
+			c.So(err, ShouldBeNil)
+			lines := strings.Split(out, "\n")
+			c.So(lines[0], ShouldEndWith, "#1 roomCreated players=4,5,6")
+			c.So(out, ShouldContainSubstring, "guessTimeout player=4")
+			c.So(out, ShouldContainSubstring, "gameOver")
+			c.So(out, ShouldContainSubstring, "  secret=3\n")
+		})
+
+		Convey("Admin commands need the token", func(c C) {
+			_, err := roomsctl("", "sessions")
+			c.So(errors.Is(err, global.ErrUnauthorized), ShouldBeTrue)
+			_, err = roomsctl("", "-token", "wrong", "bans")
+			c.So(errors.Is(err, global.ErrUnauthorized), ShouldBeTrue)
+		})
+
+		Convey("Admin commands", func(c C) {
+			admin := func(args ...string) string {
+				out, err := roomsctl("", append([]string{"-token", "secret"}, args...)...)
+				c.So(err, ShouldBeNil)
+				return out
+			}
+			c.So(admin("sessions"), ShouldStartWith, "SESSION")
+			c.So(admin("ban", "player", "5", "cheating"), ShouldEqual, "0 sessions\n")
+			c.So(serv.Banned("5"), ShouldBeTrue)
+			bans := admin("bans")
+			c.So(bans, ShouldStartWith, "KIND")
+			c.So(bans, ShouldContainSubstring, "cheating")
+			c.So(admin("unban", "player", "5"), ShouldBeEmpty)
+			c.So(serv.Banned("5"), ShouldBeFalse)
+			c.So(admin("close", "room1", "maintenance"), ShouldEqual, "0 sessions, players 4,5,6\n")
+			c.So(serv.Closed("room1"), ShouldBeTrue)
+			c.So(admin("clear-waiting"), ShouldStartWith, "0 sessions, players ")
+			c.So(admin("announce", "back", "soon"), ShouldEqual, "0 sessions\n")
+
+			_, err := roomsctl("", "-token", "secret", "kick", "nobody")
+			c.So(errors.Is(err, global.ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("Play reads the guess from the terminal", func(c C) {
+			// The other players guess once player 1 is matched with them.
+			go func() {
+				for ctx.Err() == nil {
+					if room, ok := serv.PlayerRoom("2"); ok {
+						serv.Guess("2", room.ID, 5)
+						serv.Guess("3", room.ID, 7)
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+			out, err := roomsctl("x\n/say hi\n11\n/say ho\n/say hey\n4\n", "play", "1")
+			c.So(err, ShouldBeNil)
+			c.So(out, ShouldStartWith, "waiting for a room...\njoined room ")
+			c.So(strings.Count(out, "not a number in range"), ShouldEqual, 2)
+			c.So(out, ShouldContainSubstring, "waiting for the other players...")
+			c.So(out, ShouldContainSubstring, "secret was ")
+			c.So(out, ShouldContainSubstring, "RANK")
+		})
+
+		Convey("Play without a guess fails", func(c C) {
+			_, err := roomsctl("", "play", "1")
+			c.So(err, ShouldBeError, "no guess given")
+		})
+	})
+}
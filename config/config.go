@@ -0,0 +1,151 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Addr          string
+	RatingSystem  string
+	EloK          int
+	SeasonLength  time.Duration
+	BotWait       time.Duration
+	Bots          []string
+	ChatMaxLength int
+	ChatBacklog   int
+	ChatFilter    []string
+	LogLevel      string
+	LogFormat     string
+	ShutdownGrace time.Duration
+	PingInterval  time.Duration
+	PongWait      time.Duration
+	WriteWait     time.Duration
+
+	AllowedOrigins   []string
+	ReadBufferSize   int
+	WriteBufferSize  int
+	HandshakeTimeout time.Duration
+	Subprotocols     []string
+	Compression      bool
+	MaxMessageSize   int64
+	DedupWindow      time.Duration
+	AdminToken       string
+
+	HTTPRate      float64
+	HTTPBurst     int
+	WSConnRate    float64
+	WSConnBurst   int
+	WSPlayerRate  float64
+	WSPlayerBurst int
+	ChatRate      float64
+	ChatBurst     int
+}
+
+func Load() Config {
+	return Config{
+		Addr:          getString("ROOMS_ADDR", ":8080"),
+		RatingSystem:  getString("ROOMS_RATING_SYSTEM", "fixed"),
+		EloK:          getInt("ROOMS_ELO_K", 32),
+		SeasonLength:  getDuration("ROOMS_SEASON_LENGTH", 30*24*time.Hour),
+		BotWait:       getDuration("ROOMS_BOT_WAIT", time.Minute),
+		Bots:          getList("ROOMS_BOTS", nil),
+		ChatMaxLength: getInt("ROOMS_CHAT_MAX_LENGTH", 200),
+		ChatBacklog:   getInt("ROOMS_CHAT_BACKLOG", 50),
+		ChatFilter:    getList("ROOMS_CHAT_FILTER", nil),
+		LogLevel:      getString("ROOMS_LOG_LEVEL", "info"),
+		LogFormat:     getString("ROOMS_LOG_FORMAT", "text"),
+		ShutdownGrace: getDuration("ROOMS_SHUTDOWN_GRACE", 20*time.Second),
+		PingInterval:  getDuration("ROOMS_WS_PING_INTERVAL", 25*time.Second),
+		PongWait:      getDuration("ROOMS_WS_PONG_WAIT", 60*time.Second),
+		WriteWait:     getDuration("ROOMS_WS_WRITE_WAIT", 10*time.Second),
+
+		AllowedOrigins:   getList("ROOMS_WS_ALLOWED_ORIGINS", []string{"*"}),
+		ReadBufferSize:   getInt("ROOMS_WS_READ_BUFFER", 1024),
+		WriteBufferSize:  getInt("ROOMS_WS_WRITE_BUFFER", 1024),
+		HandshakeTimeout: getDuration("ROOMS_WS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		Subprotocols:     getList("ROOMS_WS_SUBPROTOCOLS", []string{"json", "msgpack"}),
+		Compression:      getBool("ROOMS_WS_COMPRESSION", false),
+		MaxMessageSize:   int64(getInt("ROOMS_WS_MAX_MESSAGE_SIZE", 4096)),
+		DedupWindow:      getDuration("ROOMS_WS_DEDUP_WINDOW", time.Minute),
+		AdminToken:       getString("ROOMS_ADMIN_TOKEN", ""),
+
+		HTTPRate:      getFloat("ROOMS_HTTP_RATE", 5),
+		HTTPBurst:     getInt("ROOMS_HTTP_BURST", 10),
+		WSConnRate:    getFloat("ROOMS_WS_CONN_RATE", 10),
+		WSConnBurst:   getInt("ROOMS_WS_CONN_BURST", 20),
+		WSPlayerRate:  getFloat("ROOMS_WS_PLAYER_RATE", 5),
+		WSPlayerBurst: getInt("ROOMS_WS_PLAYER_BURST", 10),
+		ChatRate:      getFloat("ROOMS_CHAT_RATE", 1),
+		ChatBurst:     getInt("ROOMS_CHAT_BURST", 5),
+	}
+}
+
+func (c Config) Logger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if c.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+func getString(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && len(v) > 0 {
+		return v
+	}
+	return def
+}
+
+func getInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func getDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+func getFloat(key string, def float64) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func getBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+func getList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if len(v) == 0 {
+		return def
+	}
+	res := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			res = append(res, item)
+		}
+	}
+	return res
+}
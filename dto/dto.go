@@ -1,5 +1,10 @@
 package dto
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type RegisterRequest struct {
 	Nickname string `json:"nickname" validate:"required"`
 }
@@ -24,32 +29,102 @@ type Error struct {
 }
 
 type WebSocketRequest struct {
-	Cmd string `json:"cmd"`
+	Cmd   string `json:"cmd"`
+	ReqId string `json:"reqId"`
+	Id    string `json:"id"`
 }
 
 type JoinRequest struct {
-	Cmd string `json:"cmd"`
-	Id  string `json:"id"`
+	Cmd   string `json:"cmd" validate:"required"`
+	ReqId string `json:"reqId,omitempty" validate:"max=64"`
+	Id    string `json:"id" validate:"required"`
+}
+
+type LeaveRequest struct {
+	Cmd   string `json:"cmd" validate:"required"`
+	ReqId string `json:"reqId,omitempty" validate:"max=64"`
+	Id    string `json:"id" validate:"required"`
+}
+
+type ChatRequest struct {
+	Cmd     string `json:"cmd" validate:"required"`
+	ReqId   string `json:"reqId,omitempty" validate:"max=64"`
+	Id      string `json:"id" validate:"required"`
+	RoomId  string `json:"roomId" validate:"required"`
+	Message string `json:"message" validate:"required"`
+}
+
+type ResumeRequest struct {
+	Cmd    string `json:"cmd" validate:"required"`
+	ReqId  string `json:"reqId,omitempty" validate:"max=64"`
+	Id     string `json:"id" validate:"required"`
+	RoomId string `json:"roomId" validate:"required"`
+}
+
+type HelloRequest struct {
+	Cmd     string `json:"cmd" validate:"required"`
+	ReqId   string `json:"reqId,omitempty" validate:"max=64"`
+	Version *int   `json:"version" validate:"required"`
+}
+
+type HelloResponse struct {
+	Version    int      `json:"version"`
+	MinVersion int      `json:"minVersion"`
+	Commands   []string `json:"commands"`
+	Events     []string `json:"events"`
+	GameModes  []string `json:"gameModes"`
+	Rules      Rules    `json:"rules"`
+}
+
+type Rules struct {
+	RoomSize              int    `json:"roomSize"`
+	SecretMin             int    `json:"secretMin"`
+	SecretMax             int    `json:"secretMax"`
+	RatingSystem          string `json:"ratingSystem"`
+	MatchmakingIntervalMs int    `json:"matchmakingIntervalMs"`
+	PingIntervalMs        int    `json:"pingIntervalMs"`
+	PongWaitMs            int    `json:"pongWaitMs"`
+	ChatMaxLength         int    `json:"chatMaxLength"`
 }
 
 type GuessRequest struct {
-	Cmd    string `json:"cmd"`
-	Id     string `json:"id"`
-	RoomId string `json:"roomId"`
-	Data   int    `json:"data"`
+	Cmd    string `json:"cmd" validate:"required"`
+	ReqId  string `json:"reqId,omitempty" validate:"max=64"`
+	Id     string `json:"id" validate:"required"`
+	RoomId string `json:"roomId" validate:"required"`
+	Data   *int   `json:"data" validate:"required,min=1,max=10"`
 }
 
 type WebsocketCommandResponse struct {
-	Cmd   string `json:"cmd,omitempty"`
-	Reply string `json:"reply,omitempty"`
-	Error string `json:"error,omitempty"`
+	Cmd          string       `json:"cmd,omitempty"`
+	ReqId        string       `json:"reqId,omitempty"`
+	Reply        string       `json:"reply,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	RetryAfterMs int          `json:"retryAfterMs,omitempty"`
+	Details      []FieldError `json:"details,omitempty"`
+	Data         any          `json:"data,omitempty"`
+}
+
+type SupportedCommands struct {
+	Commands []string `json:"commands"`
+}
+
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
 }
 
 type WebsocketEventResponse struct {
-	Event    string    `json:"event,omitempty"`
-	Room     string    `json:"room,omitempty"`
-	Secret   int       `json:"secret,omitempty"`
-	Rankings []Ranking `json:"rankings,omitempty"`
+	Event    string     `json:"event,omitempty"`
+	Room     string     `json:"room,omitempty"`
+	Secret   int        `json:"secret,omitempty"`
+	Rankings []Ranking  `json:"rankings,omitempty"`
+	Reason   string     `json:"reason,omitempty"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+	Player   string     `json:"player,omitempty"`
+	Nickname string     `json:"nickname,omitempty"`
+	Message  string     `json:"message,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
 }
 
 type Ranking struct {
@@ -57,4 +132,104 @@ type Ranking struct {
 	Rank        int    `json:"rank"`
 	Guess       int    `json:"guess"`
 	DeltaTrophy int    `json:"deltaTrophy"`
+	Bot         bool   `json:"bot,omitempty"`
+}
+
+type RoomEventsResponse struct {
+	Room   string      `json:"room"`
+	Events []RoomEvent `json:"events"`
+}
+
+type RoomEvent struct {
+	Seq      int       `json:"seq"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Player   string    `json:"player,omitempty"`
+	Players  []string  `json:"players,omitempty"`
+	Bots     []string  `json:"bots,omitempty"`
+	Guess    *int      `json:"guess,omitempty"`
+	Secret   int       `json:"secret,omitempty"`
+	Rankings []Ranking `json:"rankings,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+type SeasonsResponse struct {
+	Seasons []Season `json:"seasons"`
+}
+
+type Season struct {
+	Id      int        `json:"id"`
+	Start   time.Time  `json:"start"`
+	End     *time.Time `json:"end,omitempty"`
+	Current bool       `json:"current"`
+}
+
+type LeaderboardResponse struct {
+	Season      int                `json:"season"`
+	Leaderboard []LeaderboardEntry `json:"leaderboard"`
+}
+
+type LeaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	Player   string `json:"player"`
+	Nickname string `json:"nickname"`
+	Trophies int    `json:"trophies"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+type HealthCheck struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type EventsResponse struct {
+	Events []json.RawMessage `json:"events"`
+	Close  *Close            `json:"close,omitempty"`
+}
+
+type Close struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+type ModerationRequest struct {
+	Reason string `json:"reason,omitempty" validate:"max=100"`
+}
+
+type AnnouncementRequest struct {
+	Message string `json:"message" validate:"required,max=500"`
+}
+
+// ModerationResponse tells how many sessions an admin action reached and which
+// players it affected.
+type ModerationResponse struct {
+	Sessions int      `json:"sessions"`
+	Players  []string `json:"players,omitempty"`
+}
+
+type SessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+type Session struct {
+	Id        string `json:"id"`
+	Transport string `json:"transport"`
+	Codec     string `json:"codec"`
+	Player    string `json:"player,omitempty"`
+	Room      string `json:"room,omitempty"`
+}
+
+type BansResponse struct {
+	Bans []Ban `json:"bans"`
+}
+
+type Ban struct {
+	Kind   string    `json:"kind"`
+	Value  string    `json:"value"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
 }
@@ -7,10 +7,55 @@ const (
 	NotFoundErr    = "NOT_FOUND_ERROR"
 	NotRegistered  = "NOT_REGISTERED"
 	NotInRoom      = "NOT_IN_ROOM"
+	ReplayMismatch = "REPLAY_MISMATCH"
+	NotReady       = "NOT_READY"
+	ShuttingDown   = "SHUTTING_DOWN"
+	RateLimited    = "RATE_LIMITED"
+	Unauthorized   = "UNAUTHORIZED"
+	UnsupportedVer = "UNSUPPORTED_VERSION"
+	UnknownCommand = "UNKNOWN_COMMAND"
+	Banned         = "BANNED"
+	Internal       = "INTERNAL_ERROR"
 )
 
 const CreateRoomTime = 30
 
+const RoomSize = 3
+const SecretMin = 1
+const SecretMax = 10
+
+// Websocket protocol versions understood by the server
+const ProtocolVersion = 1
+const MinProtocolVersion = 1
+
+// Websocket close code sent to clients speaking an unsupported protocol version
+const CloseUnsupportedVersion = 4001
+
+// Websocket close codes of sessions ended by an operator
+const CloseKicked = 4002
+const CloseBanned = 4003
+
+// Ban kind
+const (
+	BanPlayer   = "player"
+	BanNickname = "nickname"
+)
+
+// Game over reason
+const ServerShutdown = "serverShutdown"
+
 const WinnerPrize = 30
 const SecondPrize = 20
 const Loser = 0
+
+// Percentage of the trophies above the initial rating kept at a season reset
+const SeasonTrophyKeep = 50
+
+// Room event type
+const (
+	RoomCreated   = "roomCreated"
+	GuessReceived = "guessReceived"
+	GuessTimeout  = "guessTimeout"
+	GameFinished  = "gameOver"
+	RoomClosed    = "roomClosed"
+)
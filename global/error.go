@@ -2,14 +2,61 @@ package global
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"rooms/dto"
+	"time"
 )
 
+// Error is the error type shared by the repository, the service and both
+// transports. Its item is a stable machine code sent to clients; errors with
+// the same item match each other with errors.Is.
 type Error struct {
-	code    int
-	item    string
-	message string
+	code       int
+	item       string
+	message    string
+	details    []dto.FieldError
+	retryAfter time.Duration
+	cause      error
+}
+
+// Error kinds. They are never modified; use the With and Wrap methods to derive
+// an error with more context.
+var (
+	ErrInvalidParams      = NewError(http.StatusBadRequest, InvalidParams, "invalid parameters")
+	ErrInvalidRequest     = NewError(http.StatusBadRequest, InvalidRequest, "invalid request")
+	ErrNotFound           = NewError(http.StatusNotFound, NotFoundErr, "not found")
+	ErrNotRegistered      = NewError(http.StatusNotFound, NotRegistered, "player is not registered")
+	ErrNotInRoom          = NewError(http.StatusForbidden, NotInRoom, "player is not in the room")
+	ErrReplayMismatch     = NewError(http.StatusConflict, ReplayMismatch, "replayed game differs from the sent result")
+	ErrNotReady           = NewError(http.StatusServiceUnavailable, NotReady, "not ready")
+	ErrShuttingDown       = NewError(http.StatusServiceUnavailable, ShuttingDown, "server is shutting down")
+	ErrRateLimited        = NewError(http.StatusTooManyRequests, RateLimited, "too many requests")
+	ErrUnauthorized       = NewError(http.StatusForbidden, Unauthorized, "not allowed to act for this player")
+	ErrUnsupportedVersion = NewError(http.StatusBadRequest, UnsupportedVer, "unsupported protocol version")
+	ErrUnknownCommand     = NewError(http.StatusBadRequest, UnknownCommand, "unknown command")
+	ErrBanned             = NewError(http.StatusForbidden, Banned, "player is banned")
+	ErrInternal           = NewError(http.StatusInternalServerError, Internal, "internal error")
+)
+
+var kinds = map[string]*Error{}
+
+func init() {
+	for _, e := range []*Error{
+		ErrInvalidParams, ErrInvalidRequest, ErrNotFound, ErrNotRegistered, ErrNotInRoom, ErrReplayMismatch,
+		ErrNotReady, ErrShuttingDown, ErrRateLimited, ErrUnauthorized, ErrUnsupportedVersion, ErrUnknownCommand, ErrBanned, ErrInternal,
+	} {
+		kinds[e.item] = e
+	}
+}
+
+// Kind returns the error kind of a machine code, for example one received
+// from the server. Unknown codes get a bad request kind of their own.
+func Kind(item string) *Error {
+	if e, ok := kinds[item]; ok {
+		return e
+	}
+	return NewError(http.StatusBadRequest, item, item)
 }
 
 func NewError(code int, item, message string) *Error {
@@ -20,14 +67,85 @@ func NewError(code int, item, message string) *Error {
 	}
 }
 
+// AsError returns the *Error in err's chain, or ErrInternal wrapping err when
+// there is none.
+func AsError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal.Wrap(err)
+}
+
+// WriteError writes any error as a JSON error response.
+func WriteError(w http.ResponseWriter, err error) {
+	AsError(err).WriteError(w)
+}
+
 func (x *Error) Error() string {
+	if x.cause != nil {
+		return x.message + ": " + x.cause.Error()
+	}
 	return x.message
 }
 
+func (x *Error) Unwrap() error {
+	return x.cause
+}
+
+// Is reports whether target is an *Error with the same item.
+func (x *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.item == x.item
+}
+
+// Code is the stable machine code of the error.
+func (x *Error) Code() string {
+	return x.item
+}
+
+// Status is the HTTP status of the error.
+func (x *Error) Status() int {
+	return x.code
+}
+
+func (x *Error) Details() []dto.FieldError {
+	return x.details
+}
+
+func (x *Error) RetryAfter() time.Duration {
+	return x.retryAfter
+}
+
 func (x *Error) SetErrorMessage(message string) {
 	x.message = message
 }
 
+func (x *Error) WithMessage(message string) *Error {
+	e := *x
+	e.message = message
+	return &e
+}
+
+func (x *Error) WithDetails(details []dto.FieldError) *Error {
+	e := *x
+	e.details = details
+	return &e
+}
+
+func (x *Error) WithRetryAfter(d time.Duration) *Error {
+	e := *x
+	e.retryAfter = d
+	return &e
+}
+
+// Wrap returns a copy of the error caused by cause.
+func (x *Error) Wrap(cause error) *Error {
+	e := *x
+	e.cause = cause
+	return &e
+}
+
 func (x *Error) WriteError(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(x.code)
@@ -0,0 +1,227 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"rooms/dto"
+	"rooms/global"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WithAdminToken sets the bearer token of the admin endpoints. The admin
+// endpoints are disabled without a token.
+func WithAdminToken(token string) func(*Handler) {
+	return func(h *Handler) {
+		h.adminToken = token
+	}
+}
+
+// Admin rejects requests without the admin bearer token.
+func (a *Handler) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(a.adminToken) == 0 {
+			global.ErrUnauthorized.WithMessage("admin API is disabled").WriteError(w)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) != 1 {
+			a.logger.Warn("admin request with a wrong token", "remote", r.RemoteAddr, "path", r.URL.Path)
+			global.ErrUnauthorized.WithMessage("admin token required").WriteError(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Sessions lists the connected websocket and polling sessions.
+func (a *Handler) Sessions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := dto.SessionsResponse{Sessions: make([]dto.Session, 0)}
+		for _, s := range a.allSessions() {
+			transport := "websocket"
+			if s.conn == nil {
+				transport = "poll"
+			}
+			res.Sessions = append(res.Sessions, dto.Session{
+				Id:        s.id,
+				Transport: transport,
+				Codec:     s.codec.Name(),
+				Player:    s.player(),
+				Room:      s.room(),
+			})
+		}
+		sort.Slice(res.Sessions, func(i, j int) bool {
+			return res.Sessions[i].Id < res.Sessions[j].Id
+		})
+		writeResponse(w, res, http.StatusOK)
+	})
+}
+
+// Kick closes a session with the kicked close code.
+func (a *Handler) Kick() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := a.moderationRequest(w, r)
+		if !ok {
+			return
+		}
+		id := mux.Vars(r)["id"]
+		a.sessionsMutex.Lock()
+		s, found := a.sessions[id]
+		a.sessionsMutex.Unlock()
+		if !found {
+			global.ErrNotFound.WithMessage("session not found").WriteError(w)
+			return
+		}
+		s.logger.Info("Session kicked.", "reason", req.Reason)
+		s.closeWith(global.CloseKicked, reasonOr(req.Reason, "kicked"))
+		writeResponse(w, dto.ModerationResponse{Sessions: 1, Players: playerList(s.player())}, http.StatusOK)
+	})
+}
+
+func (a *Handler) Bans() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := dto.BansResponse{Bans: make([]dto.Ban, 0)}
+		for _, b := range a.service.Bans() {
+			res.Bans = append(res.Bans, dto.Ban{
+				Kind:   b.Kind,
+				Value:  b.Value,
+				Reason: b.Reason,
+				Time:   b.CreatedAt,
+			})
+		}
+		writeResponse(w, res, http.StatusOK)
+	})
+}
+
+// Ban bans the player id or nickname in the path and closes the sessions of
+// the banned players.
+func (a *Handler) Ban() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := a.moderationRequest(w, r)
+		if !ok {
+			return
+		}
+		vars := mux.Vars(r)
+		if _, err := a.service.Ban(vars["kind"], vars["value"], req.Reason); err != nil {
+			global.WriteError(w, err)
+			return
+		}
+		res := dto.ModerationResponse{}
+		for _, s := range a.allSessions() {
+			if player := s.player(); len(player) > 0 && a.service.Banned(player) {
+				s.logger.Info("Session of a banned player closed.", "player", player)
+				s.closeWith(global.CloseBanned, reasonOr(req.Reason, "banned"))
+				res.Sessions++
+				res.Players = append(res.Players, player)
+			}
+		}
+		writeResponse(w, res, http.StatusOK)
+	})
+}
+
+func (a *Handler) Unban() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := a.service.Unban(vars["kind"], vars["value"]); err != nil {
+			global.WriteError(w, err)
+			return
+		}
+		writeResponse(w, dto.ModerationResponse{}, http.StatusOK)
+	})
+}
+
+// CloseRoom ends the game of a room without ranking it and sends roomClosed to
+// its players.
+func (a *Handler) CloseRoom() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, ok := a.moderationRequest(w, r)
+		if !ok {
+			return
+		}
+		room, err := a.service.CloseRoom(mux.Vars(r)["id"], req.Reason)
+		if err != nil {
+			global.WriteError(w, err)
+			return
+		}
+		event := &dto.WebsocketEventResponse{
+			Event:  "roomClosed",
+			Room:   room.ID,
+			Reason: req.Reason,
+		}
+		res := dto.ModerationResponse{}
+		for _, p := range room.Players {
+			res.Players = append(res.Players, p.ID)
+		}
+		for _, s := range a.allSessions() {
+			for _, p := range room.Players {
+				if s.player() == p.ID {
+					s.setRoom("")
+					a.send(s, event)
+					res.Sessions++
+					break
+				}
+			}
+		}
+		writeResponse(w, res, http.StatusOK)
+	})
+}
+
+func (a *Handler) ClearWaitingList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, dto.ModerationResponse{Players: a.service.ClearWaitingList()}, http.StatusOK)
+	})
+}
+
+// Announce sends an announcement event to every session.
+func (a *Handler) Announce() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := dto.AnnouncementRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if err := validate.Struct(req); err != nil {
+			global.ErrInvalidParams.WithMessage(err.Error()).WriteError(w)
+			return
+		}
+		now := time.Now()
+		event := &dto.WebsocketEventResponse{
+			Event:   "announcement",
+			Message: req.Message,
+			Time:    &now,
+		}
+		res := dto.ModerationResponse{}
+		for _, s := range a.allSessions() {
+			a.send(s, event)
+			res.Sessions++
+		}
+		a.logger.Info("announcement sent", "sessions", res.Sessions)
+		writeResponse(w, res, http.StatusOK)
+	})
+}
+
+// moderationRequest decodes the optional body of an admin action.
+func (a *Handler) moderationRequest(w http.ResponseWriter, r *http.Request) (dto.ModerationRequest, bool) {
+	req := dto.ModerationRequest{}
+	json.NewDecoder(r.Body).Decode(&req)
+	if err := validate.Struct(req); err != nil {
+		global.ErrInvalidParams.WithMessage(err.Error()).WriteError(w)
+		return req, false
+	}
+	return req, true
+}
+
+func reasonOr(reason, def string) string {
+	if len(reason) > 0 {
+		return reason
+	}
+	return def
+}
+
+func playerList(id string) []string {
+	if len(id) == 0 {
+		return nil
+	}
+	return []string{id}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"rooms/dto"
+	"rooms/global"
+	"rooms/model"
+	"rooms/ratelimit"
+)
+
+// WithChatRateLimit sets the token bucket of the chat messages of a player.
+func WithChatRateLimit(rule ratelimit.Rule) func(*Handler) {
+	return func(h *Handler) {
+		h.chatLimiter = ratelimit.New(rule)
+	}
+}
+
+// chat sends a message to every player of the sender's room, the sender
+// included. Like limitCommand, sessions not bound to the player use a bucket
+// of their own.
+func (a *Handler) chat(ctx *CommandContext, req *dto.ChatRequest) (string, error) {
+	key := req.Id
+	if ctx.session.player() != req.Id {
+		key = "session:" + ctx.session.id
+	}
+	if ok, retryAfter := a.chatLimiter.Allow(key); !ok {
+		return "", global.ErrRateLimited.WithRetryAfter(retryAfter)
+	}
+	m, err := a.service.Chat(req.Id, req.RoomId, req.Message)
+	if err != nil {
+		return "", err
+	}
+	ctx.session.setPlayer(req.Id)
+	ctx.Logger.Info("Chat message received", "room", req.RoomId)
+	ctx.After(func() {
+		a.broadcastChat(m)
+	})
+	return "chatSent", nil
+}
+
+// resume binds a reconnected session to the player's room, sends the chat
+// backlog of the room and watches the room for its gameOver.
+func (a *Handler) resume(ctx *CommandContext, req *dto.ResumeRequest) (string, error) {
+	backlog, err := a.service.ChatBacklog(req.Id, req.RoomId)
+	if err != nil {
+		return "", err
+	}
+	ctx.session.setPlayer(req.Id)
+	ctx.session.setRoom(req.RoomId)
+	ctx.Logger.Info("Session resumed", "room", req.RoomId, "backlog", len(backlog))
+	ctx.After(func() {
+		for _, m := range backlog {
+			a.send(ctx.session, chatMessageEvent(m))
+		}
+		a.watchGameOver(ctx.session, req.RoomId)
+	})
+	return "resumed", nil
+}
+
+func (a *Handler) broadcastChat(m model.ChatMessage) {
+	room, err := a.service.Room(m.RoomID)
+	if err != nil {
+		a.logger.Error("Could not get the room of a chat message", "room", m.RoomID, "err", err)
+		return
+	}
+	event := chatMessageEvent(m)
+	for _, s := range a.allSessions() {
+		for _, p := range room.Players {
+			if s.player() == p.ID {
+				a.send(s, event)
+				break
+			}
+		}
+	}
+}
+
+func chatMessageEvent(m model.ChatMessage) *dto.WebsocketEventResponse {
+	return &dto.WebsocketEventResponse{
+		Event:    "chatMessage",
+		Room:     m.RoomID,
+		Player:   m.PlayerID,
+		Nickname: m.NickName,
+		Message:  m.Message,
+		Time:     &m.Time,
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes the dto types exchanged over a websocket. The codec of a
+// connection is chosen by its negotiated Sec-WebSocket-Protocol.
+type Codec interface {
+	Name() string
+	// MessageType is the websocket frame type the codec writes.
+	MessageType() int
+	Marshal(v any) ([]byte, error)
+	Unmarshal(b []byte, v any) error
+	// UnmarshalStrict rejects fields that do not exist in v.
+	UnmarshalStrict(b []byte, v any) error
+}
+
+var codecs = map[string]Codec{
+	"json":    jsonCodec{},
+	"msgpack": msgpackCodec{},
+}
+
+// codecFor returns the codec of a negotiated subprotocol, falling back to JSON
+// when no or an unknown subprotocol was negotiated.
+func codecFor(subprotocol string) Codec {
+	if c, ok := codecs[subprotocol]; ok {
+		return c
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v any) error {
+	return json.Unmarshal(b, v)
+}
+
+func (jsonCodec) UnmarshalStrict(b []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// msgpackCodec encodes the dto types by their json tags so that both formats
+// share the same field names.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c msgpackCodec) Unmarshal(b []byte, v any) error {
+	return c.decode(b, v, false)
+}
+
+func (c msgpackCodec) UnmarshalStrict(b []byte, v any) error {
+	return c.decode(b, v, true)
+}
+
+func (msgpackCodec) decode(b []byte, v any, strict bool) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(b))
+	decoder.SetCustomStructTag("json")
+	decoder.DisallowUnknownFields(strict)
+	return decoder.Decode(v)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"rooms/dto"
+	"rooms/global"
+	"time"
+)
+
+func (a *Handler) join(ctx *CommandContext, req *dto.JoinRequest) (string, error) {
+	if a.draining.Load() {
+		return "", global.ErrShuttingDown
+	}
+	if err := a.service.Join(req.Id); err != nil {
+		return "", err
+	}
+	ctx.session.setPlayer(req.Id)
+	// The player waits again, so teardown has to take it off the waiting list.
+	ctx.session.setRoom("")
+	ctx.Logger.Info("Player joined the waiting list")
+	ctx.After(func() {
+		a.watchJoinedRoom(ctx.session, req.Id)
+	})
+	return "waiting", nil
+}
+
+func (a *Handler) leave(ctx *CommandContext, req *dto.LeaveRequest) (string, error) {
+	if err := a.service.Leave(req.Id); err != nil {
+		return "", err
+	}
+	ctx.session.setPlayer(req.Id)
+	ctx.Logger.Info("Player left the waiting list")
+	return "left", nil
+}
+
+func (a *Handler) guess(ctx *CommandContext, req *dto.GuessRequest) (string, error) {
+	if err := a.service.Guess(req.Id, req.RoomId, *req.Data); err != nil {
+		return "", err
+	}
+	ctx.session.setPlayer(req.Id)
+	ctx.session.setRoom(req.RoomId)
+	ctx.Logger.Info("Guess received", "room", req.RoomId, "guess", *req.Data)
+	ctx.After(func() {
+		a.watchGameOver(ctx.session, req.RoomId)
+	})
+	return "guessReceived", nil
+}
+
+// watchJoinedRoom sends the joinedRoom event once the matchmaker placed the
+// player into a room.
+func (a *Handler) watchJoinedRoom(s *session, id string) {
+	if !s.watch("joinedRoom:" + id) {
+		return
+	}
+	tickerCreateRoomTime := time.NewTicker(a.matchInterval)
+	go func() {
+		defer tickerCreateRoomTime.Stop()
+		defer s.unwatch("joinedRoom:" + id)
+		logger := s.logger.With("player", id)
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-tickerCreateRoomTime.C:
+				a.service.CreateRooms()
+				if room, ok := a.service.PlayerRoom(id); ok {
+					res := &dto.WebsocketEventResponse{
+						Event: "joinedRoom",
+						Room:  room.ID,
+					}
+					if err := s.send(res); err != nil {
+						logger.Error("Could not write message to websocket", "room", room.ID, "err", err)
+						return
+					}
+					s.setRoom(room.ID)
+					logger.Info("Player joined room", "room", room.ID)
+					return
+				}
+			}
+		}
+	}()
+}
+
+// watchGameOver sends the gameOver event once every player of the room guessed.
+func (a *Handler) watchGameOver(s *session, roomId string) {
+	if !s.watch("gameOver:" + roomId) {
+		return
+	}
+	tickerCreateRoomTime := time.NewTicker(1 * time.Second)
+	go func() {
+		defer tickerCreateRoomTime.Stop()
+		logger := s.logger.With("room", roomId)
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-tickerCreateRoomTime.C:
+				if a.service.Closed(roomId) {
+					return
+				}
+				if a.service.AllGuessDone(roomId) {
+					a.service.GameOver(roomId)
+					gameResults := a.service.GetGameResults(roomId)
+					res := gameOverResponse(gameResults)
+					if err := s.send(res); err != nil {
+						logger.Error("Could not write message to websocket", "err", err)
+						return
+					}
+					logger.Info("Game over sent", "secret", gameResults.Secret)
+					return
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// WithDedupWindow sets how long successful replies are remembered by reqId so
+// that retried commands are answered without being executed again.
+func WithDedupWindow(d time.Duration) func(*Handler) {
+	return func(h *Handler) {
+		h.replies = newReplyCache(d)
+	}
+}
+
+type replyCache struct {
+	window    time.Duration
+	entries   map[string]cachedReply
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type cachedReply struct {
+	reply string
+	data  any
+	at    time.Time
+}
+
+func newReplyCache(window time.Duration) *replyCache {
+	return &replyCache{
+		window:    window,
+		entries:   map[string]cachedReply{},
+		lastPrune: time.Now(),
+	}
+}
+
+func (c *replyCache) get(key string) (cachedReply, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.at) > c.window {
+		return cachedReply{}, false
+	}
+	return e, true
+}
+
+func (c *replyCache) put(key, reply string, data any) {
+	now := time.Now()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if now.Sub(c.lastPrune) > c.window {
+		for k, e := range c.entries {
+			if now.Sub(e.at) > c.window {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	c.entries[key] = cachedReply{reply: reply, data: data, at: now}
+}
+
+// deduplicate answers a command carrying an already seen reqId with the reply
+// of its first execution on the same session. A retry over a new connection is
+// executed again, since what the command bound to the old session, like the
+// waiting list entry and the joinedRoom watcher, was torn down with it.
+func (a *Handler) deduplicate(next CommandFunc) CommandFunc {
+	return func(ctx *CommandContext) (string, error) {
+		if len(ctx.ReqId) == 0 || a.replies.window <= 0 {
+			return next(ctx)
+		}
+		key := ctx.session.id + ":" + ctx.Cmd + ":" + ctx.ReqId
+		if cached, ok := a.replies.get(key); ok {
+			ctx.Logger.Info("duplicate command answered from cache")
+			ctx.SetData(cached.data)
+			return cached.reply, nil
+		}
+		reply, err := next(ctx)
+		if err == nil && len(reply) > 0 {
+			a.replies.put(key, reply, ctx.data)
+		}
+		return reply, err
+	}
+}
@@ -2,28 +2,75 @@ package handler
 
 import (
 	"encoding/json"
-	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
-	"log"
+	"log/slog"
 	"net/http"
 	"rooms/dto"
 	"rooms/global"
+	"rooms/metrics"
+	"rooms/model"
+	"rooms/ratelimit"
 	"rooms/service"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Handler struct {
-	service *service.Service
-	conn    *websocket.Conn
-	sync.Mutex
+	service         *service.Service
+	logger          *slog.Logger
+	draining        atomic.Bool
+	sessions        map[string]*session
+	sessionsMutex   sync.Mutex
+	pingInterval    time.Duration
+	pongWait        time.Duration
+	writeWait       time.Duration
+	upgraderOptions upgraderOptions
+	upgrader        *websocket.Upgrader
+	readLimit       int64
+	httpLimiter     *ratelimit.Limiter
+	connLimiter     *ratelimit.Limiter
+	playerLimiter   *ratelimit.Limiter
+	chatLimiter     *ratelimit.Limiter
+	commands        map[string]CommandFunc
+	middleware      []Middleware
+	replies         *replyCache
+	polling         map[string]*pollSession
+	adminToken      string
+	matchInterval   time.Duration
 }
 
 func NewHandler(options ...func(*Handler)) *Handler {
-	as := &Handler{}
+	as := &Handler{
+		logger:          slog.Default(),
+		sessions:        map[string]*session{},
+		pingInterval:    25 * time.Second,
+		pongWait:        60 * time.Second,
+		writeWait:       10 * time.Second,
+		upgraderOptions: defaultUpgraderOptions(),
+		readLimit:       4096,
+		httpLimiter:     ratelimit.New(ratelimit.Rule{PerSecond: 5, Burst: 10}),
+		connLimiter:     ratelimit.New(ratelimit.Rule{PerSecond: 10, Burst: 20}),
+		playerLimiter:   ratelimit.New(ratelimit.Rule{PerSecond: 5, Burst: 10}),
+		chatLimiter:     ratelimit.New(ratelimit.Rule{PerSecond: 1, Burst: 5}),
+		commands:        map[string]CommandFunc{},
+		replies:         newReplyCache(time.Minute),
+		polling:         map[string]*pollSession{},
+		matchInterval:   global.CreateRoomTime * time.Second,
+	}
+	as.HandleCommand("hello", Typed(as.hello))
+	as.HandleCommand("join", Typed(as.join))
+	as.HandleCommand("leave", Typed(as.leave))
+	as.HandleCommand("guess", Typed(as.guess))
+	as.HandleCommand("chat", Typed(as.chat))
+	as.HandleCommand("resume", Typed(as.resume))
+	as.UseCommandMiddleware(as.logCommand, as.authenticate, as.limitCommand, as.deduplicate)
 	for _, o := range options {
 		o(as)
 	}
+	as.upgrader = as.newUpgrader()
 	return as
 }
 
@@ -33,21 +80,52 @@ func WithService(s *service.Service) func(*Handler) {
 	}
 }
 
+func WithLogger(l *slog.Logger) func(*Handler) {
+	return func(h *Handler) {
+		h.logger = l
+	}
+}
+
+// WithHeartbeat sets how often connections are pinged, how long a silent
+// connection is kept open and how long a single write may take.
+func WithHeartbeat(pingInterval, pongWait, writeWait time.Duration) func(*Handler) {
+	return func(h *Handler) {
+		h.pingInterval = pingInterval
+		h.pongWait = pongWait
+		h.writeWait = writeWait
+	}
+}
+
+// WithMatchmakingInterval sets how often a joined session runs the matchmaker
+// and checks whether its player was placed into a room.
+func WithMatchmakingInterval(d time.Duration) func(*Handler) {
+	return func(h *Handler) {
+		h.matchInterval = d
+	}
+}
+
+// WithReadLimit sets the maximum size in bytes of an inbound websocket message.
+// Larger messages close the connection with a "message too big" close frame.
+func WithReadLimit(n int64) func(*Handler) {
+	return func(h *Handler) {
+		h.readLimit = n
+	}
+}
+
 func (a *Handler) Register() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		input := dto.RegisterRequest{}
 		json.NewDecoder(r.Body).Decode(&input)
-		err := validator.New().Struct(input)
+		err := validate.Struct(input)
 		if err != nil {
-			log.Printf("err occurred while parsing register input: %s \n", err.Error())
-			err = global.NewError(http.StatusBadRequest, global.InvalidParams, err.Error())
-			err.(*global.Error).WriteError(w)
+			a.logger.Warn("err occurred while parsing register input", "err", err)
+			global.ErrInvalidParams.WithMessage(err.Error()).WriteError(w)
 			return
 		}
 		id, err := a.service.Register(input.Nickname)
 		if err != nil {
-			log.Printf("err occurred while registering : %s \n", err.Error())
-			err.(*global.Error).WriteError(w)
+			a.logger.Error("err occurred while registering", "nickname", input.Nickname, "err", err)
+			global.WriteError(w, err)
 
 			return
 		}
@@ -59,8 +137,8 @@ func (a *Handler) Stats() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		stats, err := a.service.Stats()
 		if err != nil {
-			log.Printf("err occurred while getting stats : %s \n", err.Error())
-			err.(*global.Error).WriteError(w)
+			a.logger.Error("err occurred while getting stats", "err", err)
+			global.WriteError(w, err)
 
 			return
 		}
@@ -78,239 +156,170 @@ func (a *Handler) Stats() http.Handler {
 	})
 }
 
-func (a *Handler) Websocket() http.Handler {
+func (a *Handler) RoomEvents() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		roomId := mux.Vars(r)["id"]
+		events, err := a.service.Events(roomId)
 		if err != nil {
-			log.Println("Websocket Connection error : ", err)
+			a.logger.Warn("err occurred while getting room events", "room", roomId, "err", err)
+			global.WriteError(w, err)
+
 			return
 		}
-		log.Println("Websocket Connection established.")
-		a.conn = conn
-		defer a.conn.Close()
+		res := dto.RoomEventsResponse{
+			Room:   roomId,
+			Events: make([]dto.RoomEvent, 0),
+		}
+		for _, e := range events {
+			event := dto.RoomEvent{
+				Seq:     e.Seq,
+				Type:    e.Type,
+				Time:    e.Time,
+				Player:  e.PlayerID,
+				Players: e.Players,
+				Bots:    e.Bots,
+				Secret:  e.Secret,
+				Reason:  e.Reason,
+			}
+			if e.Type == global.GuessReceived {
+				guess := e.Guess
+				event.Guess = &guess
+			}
+			for _, r := range e.Rankings {
+				event.Rankings = append(event.Rankings, dto.Ranking{
+					Player:      r.Player.ID,
+					Rank:        r.Rank,
+					Guess:       r.Player.Guess,
+					DeltaTrophy: r.DeltaTrophy,
+					Bot:         r.Player.Bot,
+				})
+			}
+			res.Events = append(res.Events, event)
+		}
+		writeResponse(w, res, http.StatusOK)
+	})
+}
 
-		clientId := make(chan string)
-		guessRoomId := make(chan string)
+func (a *Handler) Seasons() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := dto.SeasonsResponse{
+			Seasons: make([]dto.Season, 0),
+		}
+		for _, s := range a.service.Seasons() {
+			season := dto.Season{
+				Id:      s.ID,
+				Start:   s.Start,
+				Current: s.End.IsZero(),
+			}
+			if !season.Current {
+				end := s.End
+				season.End = &end
+			}
+			res.Seasons = append(res.Seasons, season)
+		}
+		writeResponse(w, res, http.StatusOK)
+	})
+}
+
+func (a *Handler) SeasonLeaderboard() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			a.logger.Warn("err occurred while parsing season id", "err", err)
+			global.ErrInvalidParams.WithMessage("season id must be a number").WriteError(w)
+			return
+		}
+		leaderboard, err := a.service.SeasonLeaderboard(id)
+		if err != nil {
+			a.logger.Warn("err occurred while getting season leaderboard", "season", id, "err", err)
+			global.WriteError(w, err)
 
-		a.handleJoinedRoomEvent(a.conn, clientId)
-		a.handleGameOverEvent(a.conn, guessRoomId)
-		a.handleCommand(a.conn, clientId, guessRoomId)
+			return
+		}
+		res := dto.LeaderboardResponse{
+			Season:      id,
+			Leaderboard: make([]dto.LeaderboardEntry, 0),
+		}
+		for _, l := range leaderboard {
+			res.Leaderboard = append(res.Leaderboard, dto.LeaderboardEntry{
+				Rank:     l.Rank,
+				Player:   l.PlayerID,
+				Nickname: l.NickName,
+				Trophies: l.Trophies,
+			})
+		}
+		writeResponse(w, res, http.StatusOK)
 	})
 }
 
-func (a *Handler) handleCommand(conn *websocket.Conn, clientId chan string, guessRoomId chan string) {
+func (a *Handler) Websocket() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.draining.Load() {
+			global.ErrShuttingDown.WriteError(w)
+			return
+		}
+		conn, err := a.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			a.logger.Warn("Websocket Connection error", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		conn.SetReadLimit(a.readLimit)
+		s := newWebsocketSession(conn, a.logger, a.writeWait)
+		s.logger.Info("Websocket Connection established.", "remote", r.RemoteAddr)
+		metrics.ConnectedWebsockets.Inc()
+		defer metrics.ConnectedWebsockets.Dec()
+		a.addSession(s)
+		defer a.teardown(s)
+		a.keepAlive(s)
+		a.handleCommand(s)
+	})
+}
+
+func (a *Handler) handleCommand(s *session) {
 	for {
-		_, b, err := conn.ReadMessage()
+		_, b, err := s.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err) {
+			if _, ok := err.(*websocket.CloseError); ok {
+				s.logger.Info("Websocket Connection closed.", "err", err)
 				return
 			}
-			log.Println("Could not read message from websocket, error : ", err)
-			res := dto.WebsocketCommandResponse{
-				Error: global.InvalidRequest,
-			}
-			b, err := json.Marshal(res)
-			a.Lock()
-			if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
-				log.Println("Could not write message to websocket, error", err)
-			}
-			a.Unlock()
-			continue
+			s.logger.Warn("Could not read message from websocket", "err", err)
+			return
 		}
+		s.conn.SetReadDeadline(time.Now().Add(a.pongWait))
 		commandRequest := &dto.WebSocketRequest{}
-		err = json.Unmarshal(b, commandRequest)
+		err = s.codec.Unmarshal(b, commandRequest)
 		if err != nil {
-			log.Println("Could not unmarshal the read message of websocket, error", err)
+			s.logger.Warn("Could not unmarshal the read message of websocket", "err", err)
+			metrics.CommandErrors.WithLabelValues("", global.InvalidRequest).Inc()
 			res := dto.WebsocketCommandResponse{
 				Error: global.InvalidRequest,
 			}
-			b, err := json.Marshal(res)
-			a.Lock()
-			if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
-				log.Println("Could not write message to websocket, error", err)
+			if err = s.send(res); err != nil {
+				s.logger.Error("Could not write message to websocket", "err", err)
 			}
-			a.Unlock()
 			continue
 		}
-
-		switch commandRequest.Cmd {
-		case "join":
-			joinRequest := &dto.JoinRequest{}
-			err = json.Unmarshal(b, joinRequest)
-			if err != nil {
-				log.Println("Could not unmarshal the join message of websocket, error", err)
-				res := dto.WebsocketCommandResponse{
-					Cmd:   commandRequest.Cmd,
-					Error: global.InvalidParams,
-				}
-				b, err := json.Marshal(res)
-				a.Lock()
-				if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
-					log.Println("Could not write message to websocket, error", err)
-				}
-				a.Unlock()
-				continue
-			}
-			err = a.service.Join(joinRequest.Id)
-			if err != nil {
-				log.Println("Err occurred while join process, error", err)
-				res := dto.WebsocketCommandResponse{
-					Cmd:   commandRequest.Cmd,
-					Error: err.Error(),
-				}
-				b, err := json.Marshal(res)
-				a.Lock()
-				if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
-					log.Println("Could not write message to websocket, error", err)
-				}
-				a.Unlock()
-				continue
-			}
-			res := dto.WebsocketCommandResponse{
-				Cmd:   commandRequest.Cmd,
-				Reply: "waiting",
-			}
-			databytes, err := json.Marshal(res)
-			a.Lock()
-			if err = conn.WriteMessage(websocket.TextMessage, databytes); err != nil {
-				log.Println("Could not write message to websocket, error", err)
-				a.Unlock()
-				continue
-			}
-			a.Unlock()
-			clientId <- joinRequest.Id
-		case "guess":
-			guessRequest := &dto.GuessRequest{}
-			err = json.Unmarshal(b, guessRequest)
-			if err != nil {
-				log.Println("Could not unmarshal the guess message of websocket, error", err)
-				res := dto.WebsocketCommandResponse{
-					Cmd:   commandRequest.Cmd,
-					Error: global.InvalidParams,
-				}
-				b, err := json.Marshal(res)
-				a.Lock()
-				if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
-					log.Println("Could not write message to websocket, error", err)
-				}
-				a.Unlock()
-				continue
-			}
-			err = a.service.Guess(guessRequest.Id, guessRequest.RoomId, guessRequest.Data)
-			if err != nil {
-				log.Println("Err occurred while guess process, error", err)
-				res := dto.WebsocketCommandResponse{
-					Cmd:   commandRequest.Cmd,
-					Error: err.Error(),
-				}
-				b, err := json.Marshal(res)
-				a.Lock()
-				if err = conn.WriteMessage(websocket.TextMessage, b); err != nil {
-					log.Println("Could not write message to websocket, error", err)
-				}
-				a.Unlock()
-				continue
-			}
-			res := dto.WebsocketCommandResponse{
-				Cmd:   commandRequest.Cmd,
-				Reply: "guessReceived",
-			}
-			databytes, err := json.Marshal(res)
-			a.Lock()
-			if err = conn.WriteMessage(websocket.TextMessage, databytes); err != nil {
-				log.Println("Could not write message to websocket, error", err)
-				a.Unlock()
-				continue
-			}
-			a.Unlock()
-			guessRoomId <- guessRequest.RoomId
-		default:
-			log.Println("Not supported command, ", commandRequest.Cmd)
-		}
+		a.dispatch(s, commandRequest, b)
 	}
 }
 
-func (a *Handler) handleJoinedRoomEvent(conn *websocket.Conn, clientId chan string) {
-	tickerCreateRoomTime := time.NewTicker(global.CreateRoomTime * time.Second)
-	done := make(chan bool)
-	go func() {
-		id := <-clientId
-		for {
-			select {
-			case <-done:
-				return
-			case <-tickerCreateRoomTime.C:
-				a.Lock()
-				rooms := a.service.CreateRooms()
-				a.Unlock()
-				roomId := ""
-				for _, room := range rooms {
-					for _, player := range room.Players {
-						if player.ID == id {
-							roomId = room.ID
-							break
-						}
-					}
-				}
-
-				if len(roomId) > 0 {
-					res := &dto.WebsocketEventResponse{
-						Event: "joinedRoom",
-						Room:  roomId,
-					}
-					databytes, err := json.Marshal(res)
-					a.Lock()
-					if err = conn.WriteMessage(websocket.TextMessage, databytes); err != nil {
-						log.Println("Could not write message to websocket, error", err)
-						a.Unlock()
-						return
-					}
-					a.Unlock()
-					done <- true
-				}
-			}
-		}
-	}()
-}
-
-func (a *Handler) handleGameOverEvent(conn *websocket.Conn, guessRoomId chan string) {
-	tickerCreateRoomTime := time.NewTicker(1 * time.Second)
-	done := make(chan bool)
-	go func() {
-		roomId := <-guessRoomId
-		for {
-			select {
-			case <-done:
-				return
-			case <-tickerCreateRoomTime.C:
-				if a.service.AllGuessDone(roomId) {
-					a.service.GameOver(roomId)
-					gameResults := a.service.GetGameResults(roomId)
-					ranking := make([]dto.Ranking, 0)
-					for _, r := range gameResults.Rankings {
-						ranking = append(ranking, dto.Ranking{
-							Player:      r.Player.ID,
-							Rank:        r.Rank,
-							Guess:       r.Player.Guess,
-							DeltaTrophy: r.DeltaTrophy,
-						})
-					}
-					res := &dto.WebsocketEventResponse{
-						Event:    "gameOver",
-						Secret:   gameResults.Secret,
-						Rankings: ranking,
-					}
-					databytes, err := json.Marshal(res)
-					a.Lock()
-					if err = conn.WriteMessage(websocket.TextMessage, databytes); err != nil {
-						log.Println("Could not write message to websocket, error", err)
-					}
-					a.Unlock()
-					done <- true
-				}
-			}
-		}
-	}()
+func gameOverResponse(gameResults model.GameResult) *dto.WebsocketEventResponse {
+	ranking := make([]dto.Ranking, 0)
+	for _, r := range gameResults.Rankings {
+		ranking = append(ranking, dto.Ranking{
+			Player:      r.Player.ID,
+			Rank:        r.Rank,
+			Guess:       r.Player.Guess,
+			DeltaTrophy: r.DeltaTrophy,
+			Bot:         r.Player.Bot,
+		})
+	}
+	return &dto.WebsocketEventResponse{
+		Event:    "gameOver",
+		Secret:   gameResults.Secret,
+		Rankings: ranking,
+	}
 }
 
 func writeResponse(w http.ResponseWriter, v any, responseCode int) {
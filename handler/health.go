@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"rooms/dto"
+	"rooms/global"
+	"time"
+)
+
+const (
+	statusOk   = "ok"
+	statusFail = "fail"
+)
+
+func (a *Handler) SetDraining() {
+	a.draining.Store(true)
+}
+
+func (a *Handler) Healthz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, dto.HealthResponse{Status: statusOk}, http.StatusOK)
+	})
+}
+
+func (a *Handler) Readyz() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := dto.HealthResponse{
+			Status: statusOk,
+			Checks: map[string]dto.HealthCheck{},
+		}
+		check := func(name string, err error) {
+			if err != nil {
+				res.Status = statusFail
+				res.Checks[name] = dto.HealthCheck{Status: statusFail, Message: err.Error()}
+				return
+			}
+			res.Checks[name] = dto.HealthCheck{Status: statusOk}
+		}
+
+		check("repository", a.service.Ping())
+		check("matchmaker", a.checkMatchmaker())
+		check("shutdown", a.checkDraining())
+
+		code := http.StatusOK
+		if res.Status != statusOk {
+			a.logger.Warn("readiness check failed", "checks", res.Checks)
+			code = http.StatusServiceUnavailable
+		}
+		writeResponse(w, res, code)
+	})
+}
+
+func (a *Handler) checkMatchmaker() error {
+	last := a.service.LastMatchmaking()
+	if last.IsZero() {
+		return fmt.Errorf("matchmaker has not run yet")
+	}
+	if since := time.Since(last); since > 2*global.CreateRoomTime*time.Second {
+		return fmt.Errorf("matchmaker last ran %s ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (a *Handler) checkDraining() error {
+	if a.draining.Load() {
+		return fmt.Errorf("server is shutting down")
+	}
+	return nil
+}
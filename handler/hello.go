@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"rooms/dto"
+	"rooms/global"
+	"time"
+)
+
+// hello negotiates the protocol version and tells the client what the server
+// supports. Clients speaking an unsupported version are disconnected.
+func (a *Handler) hello(ctx *CommandContext, req *dto.HelloRequest) (string, error) {
+	ctx.SetData(a.capabilities())
+	if *req.Version < global.MinProtocolVersion || *req.Version > global.ProtocolVersion {
+		ctx.Logger.Warn("unsupported protocol version", "version", *req.Version)
+		ctx.Close(global.CloseUnsupportedVersion, "unsupported protocol version")
+		return "", global.ErrUnsupportedVersion
+	}
+	return "welcome", nil
+}
+
+func (a *Handler) capabilities() dto.HelloResponse {
+	return dto.HelloResponse{
+		Version:    global.ProtocolVersion,
+		MinVersion: global.MinProtocolVersion,
+		Commands:   a.commandNames(),
+		Events:     eventNames(),
+		GameModes:  []string{"classic"},
+		Rules: dto.Rules{
+			RoomSize:              global.RoomSize,
+			SecretMin:             global.SecretMin,
+			SecretMax:             global.SecretMax,
+			RatingSystem:          a.service.RatingSystem().Name(),
+			MatchmakingIntervalMs: int(a.matchInterval / time.Millisecond),
+			PingIntervalMs:        int(a.pingInterval / time.Millisecond),
+			PongWaitMs:            int(a.pongWait / time.Millisecond),
+			ChatMaxLength:         a.service.ChatMaxLength(),
+		},
+	}
+}
+
+func eventNames() []string {
+	names := make([]string, 0, len(events))
+	for _, e := range events {
+		names = append(names, e.Name)
+	}
+	return names
+}
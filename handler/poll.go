@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"math"
+	"net/http"
+	"rooms/dto"
+	"rooms/global"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollQueueSize is the number of undelivered events kept for a polling
+// session; older events are dropped first.
+const pollQueueSize = 64
+
+// pollTransport queues the messages of a session until the client fetches them
+// with a long-poll or Server-Sent Events request.
+type pollTransport struct {
+	mutex    sync.Mutex
+	queue    []json.RawMessage
+	wake     chan struct{}
+	closed   *dto.Close
+	active   int
+	lastSeen time.Time
+}
+
+func newPollTransport() *pollTransport {
+	return &pollTransport{
+		wake:     make(chan struct{}),
+		lastSeen: time.Now(),
+	}
+}
+
+func (t *pollTransport) write(messageType int, b []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed != nil {
+		return errors.New("polling session closed")
+	}
+	if len(t.queue) == pollQueueSize {
+		t.queue = t.queue[1:]
+	}
+	t.queue = append(t.queue, append(json.RawMessage{}, b...))
+	t.notify()
+	return nil
+}
+
+func (t *pollTransport) writeClose(code int, reason string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed == nil {
+		t.closed = &dto.Close{Code: code, Reason: reason}
+		t.notify()
+	}
+	return nil
+}
+
+func (t *pollTransport) close() error {
+	return t.writeClose(http.StatusGone, "session closed")
+}
+
+// notify wakes every waiting request. It must be called with the mutex held.
+func (t *pollTransport) notify() {
+	close(t.wake)
+	t.wake = make(chan struct{})
+}
+
+// take returns the queued events, the close reason once the session is closed
+// and a channel closed on the next change.
+func (t *pollTransport) take() ([]json.RawMessage, *dto.Close, <-chan struct{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	events := t.queue
+	t.queue = nil
+	return events, t.closed, t.wake
+}
+
+func (t *pollTransport) begin() {
+	t.mutex.Lock()
+	t.active++
+	t.lastSeen = time.Now()
+	t.mutex.Unlock()
+}
+
+func (t *pollTransport) end() {
+	t.mutex.Lock()
+	t.active--
+	t.lastSeen = time.Now()
+	t.mutex.Unlock()
+}
+
+// idle reports how long no client has been fetching events.
+func (t *pollTransport) idle() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.active > 0 {
+		return 0
+	}
+	return time.Since(t.lastSeen)
+}
+
+type pollSession struct {
+	session   *session
+	transport *pollTransport
+}
+
+// pollSession returns the polling session of a player, creating it when create
+// is set. Sessions no client fetched events from within pongWait are closed.
+func (a *Handler) pollSession(playerId string, create bool) (*pollSession, bool) {
+	a.sessionsMutex.Lock()
+	defer a.sessionsMutex.Unlock()
+	if ps, ok := a.polling[playerId]; ok {
+		return ps, false
+	}
+	if !create {
+		return nil, false
+	}
+	t := newPollTransport()
+	ps := &pollSession{
+		session:   newSession(t, jsonCodec{}, a.logger.With("transport", "poll")),
+		transport: t,
+	}
+	ps.session.setPlayer(playerId)
+	a.polling[playerId] = ps
+	a.sessions[ps.session.id] = ps.session
+	ps.session.logger.Info("Polling session established.", "player", playerId)
+	go a.expire(ps)
+	return ps, true
+}
+
+func (a *Handler) expire(ps *pollSession) {
+	ticker := time.NewTicker(a.pingInterval)
+	defer ticker.Stop()
+	defer a.teardownPoll(ps)
+	for {
+		select {
+		case <-ps.session.done:
+			return
+		case <-ticker.C:
+			if ps.transport.idle() > a.pongWait {
+				ps.session.logger.Info("Polling session expired.")
+				return
+			}
+		}
+	}
+}
+
+func (a *Handler) teardownPoll(ps *pollSession) {
+	a.sessionsMutex.Lock()
+	if a.polling[ps.session.player()] == ps {
+		delete(a.polling, ps.session.player())
+	}
+	a.sessionsMutex.Unlock()
+	a.teardown(ps.session)
+}
+
+// PollCommand executes a websocket command for the player in the path over
+// plain HTTP. The body holds the command fields other than cmd and id; events
+// caused by the command are delivered through PollEvents.
+func (a *Handler) PollCommand(cmd string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		playerId := mux.Vars(r)["id"]
+		fields := map[string]json.RawMessage{}
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, a.readLimit))
+		if err == nil && len(strings.TrimSpace(string(b))) > 0 {
+			err = json.Unmarshal(b, &fields)
+		}
+		if err != nil {
+			a.logger.Warn("Could not unmarshal the polling command", "cmd", cmd, "err", err)
+			writeResponse(w, dto.WebsocketCommandResponse{Cmd: cmd, Error: global.InvalidRequest}, global.ErrInvalidRequest.Status())
+			return
+		}
+		req := &dto.WebSocketRequest{Cmd: cmd, Id: playerId}
+		json.Unmarshal(fields["reqId"], &req.ReqId)
+		fields["cmd"], _ = json.Marshal(cmd)
+		fields["id"], _ = json.Marshal(playerId)
+		payload, _ := json.Marshal(fields)
+
+		ps, created := a.pollSession(playerId, true)
+		ctx, res, err := a.execute(ps.session, req, payload)
+		if res == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			if created {
+				ps.session.close()
+			}
+			e := global.AsError(err)
+			if e.RetryAfter() > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter().Seconds()))))
+			}
+			writeResponse(w, res, e.Status())
+			return
+		}
+		writeResponse(w, res, http.StatusOK)
+		ctx.runAfter()
+	})
+}
+
+// PollEvents delivers the events of a player's polling session. Requests
+// accepting text/event-stream are served as Server-Sent Events; others are
+// long-polled for up to the "wait" query duration.
+func (a *Handler) PollEvents() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		playerId := mux.Vars(r)["id"]
+		ps, _ := a.pollSession(playerId, false)
+		if ps == nil {
+			global.ErrNotFound.WithMessage("no polling session, send join first").WriteError(w)
+			return
+		}
+		ps.transport.begin()
+		defer ps.transport.end()
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			a.stream(w, r, ps)
+			return
+		}
+		wait := a.pingInterval
+		if v := r.URL.Query().Get("wait"); len(v) > 0 {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				global.ErrInvalidParams.WithMessage("wait must be a duration").WriteError(w)
+				return
+			}
+			wait = d
+		}
+		if wait > a.pongWait/2 {
+			wait = a.pongWait / 2
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		for {
+			events, closed, wake := ps.transport.take()
+			if len(events) > 0 || closed != nil {
+				writeResponse(w, dto.EventsResponse{Events: events, Close: closed}, http.StatusOK)
+				return
+			}
+			select {
+			case <-wake:
+			case <-timer.C:
+				writeResponse(w, dto.EventsResponse{Events: []json.RawMessage{}}, http.StatusOK)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+func (a *Handler) stream(w http.ResponseWriter, r *http.Request, ps *pollSession) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		global.NewError(http.StatusNotAcceptable, global.InvalidRequest, "streaming is not supported").WriteError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(a.pingInterval)
+	defer ticker.Stop()
+	for {
+		events, closed, wake := ps.transport.take()
+		for _, e := range events {
+			fmt.Fprintf(w, "data: %s\n\n", e)
+		}
+		if closed != nil {
+			b, _ := json.Marshal(closed)
+			fmt.Fprintf(w, "event: close\ndata: %s\n\n", b)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-wake:
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"rooms/global"
+	"rooms/ratelimit"
+	"strconv"
+)
+
+// WithRateLimits sets the token buckets used per client IP for HTTP requests,
+// per websocket connection and per player for websocket commands.
+func WithRateLimits(httpRule, connRule, playerRule ratelimit.Rule) func(*Handler) {
+	return func(h *Handler) {
+		h.httpLimiter = ratelimit.New(httpRule)
+		h.connLimiter = ratelimit.New(connRule)
+		h.playerLimiter = ratelimit.New(playerRule)
+	}
+}
+
+// Limit rejects requests of clients exceeding the HTTP rate limit with a
+// RATE_LIMITED error and a Retry-After header.
+func (a *Handler) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if ok, retryAfter := a.httpLimiter.Allow(ip); !ok {
+			a.logger.Warn("request rate limited", "remote", ip, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			global.ErrRateLimited.WriteError(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitCommand rejects commands of connections or players exceeding their
+// websocket command rate with a RATE_LIMITED error. Only sessions bound to the
+// player take from its bucket, so other connections cannot drain it by
+// claiming its id.
+func (a *Handler) limitCommand(next CommandFunc) CommandFunc {
+	return func(ctx *CommandContext) (string, error) {
+		ok, retryAfter := a.connLimiter.Allow(ctx.session.id)
+		if ok && len(ctx.PlayerId) > 0 && ctx.session.player() == ctx.PlayerId {
+			ok, retryAfter = a.playerLimiter.Allow(ctx.PlayerId)
+		}
+		if !ok {
+			return "", global.ErrRateLimited.WithRetryAfter(retryAfter)
+		}
+		return next(ctx)
+	}
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"rooms/dto"
+	"rooms/global"
+	"rooms/metrics"
+	"sort"
+	"time"
+)
+
+// CommandContext carries one inbound websocket command through the middleware
+// chain to its handler.
+type CommandContext struct {
+	Cmd      string
+	ReqId    string
+	PlayerId string
+	Payload  []byte
+	Logger   *slog.Logger
+	session  *session
+	after    []func()
+	data     any
+	close    *websocket.CloseError
+}
+
+// Send writes an event to the connection the command was received on, encoded
+// with the codec of the connection.
+func (c *CommandContext) Send(v any) error {
+	return c.session.send(v)
+}
+
+// After registers f to run once the reply of the command has been written.
+func (c *CommandContext) After(f func()) {
+	c.after = append(c.after, f)
+}
+
+func (c *CommandContext) runAfter() {
+	for _, f := range c.after {
+		f()
+	}
+}
+
+// SetData attaches v to the reply of the command, whether it succeeds or fails.
+func (c *CommandContext) SetData(v any) {
+	c.data = v
+}
+
+// Close closes the connection with the given close code once the reply of the
+// command has been written, whether it succeeds or fails.
+func (c *CommandContext) Close(code int, reason string) {
+	c.close = &websocket.CloseError{Code: code, Text: reason}
+}
+
+// CommandFunc executes a websocket command and returns its reply. An empty
+// reply without error sends nothing back; errors are sent as error replies.
+type CommandFunc func(ctx *CommandContext) (string, error)
+
+type Middleware func(next CommandFunc) CommandFunc
+
+// Typed wraps exec in a CommandFunc that strictly decodes and validates the
+// payload into a T before executing it.
+func Typed[T any](exec func(ctx *CommandContext, req *T) (string, error)) CommandFunc {
+	return func(ctx *CommandContext) (string, error) {
+		req := new(T)
+		details, err := decodeCommand(ctx.session.codec, ctx.Payload, req)
+		if err != nil {
+			ctx.Logger.Warn("Could not decode the command", "err", err)
+			return "", global.ErrInvalidParams.WithDetails(details).Wrap(err)
+		}
+		return exec(ctx, req)
+	}
+}
+
+// WithCommand registers an additional websocket command.
+func WithCommand(name string, cmd CommandFunc) func(*Handler) {
+	return func(h *Handler) {
+		h.HandleCommand(name, cmd)
+	}
+}
+
+func (a *Handler) HandleCommand(name string, cmd CommandFunc) {
+	a.commands[name] = cmd
+}
+
+// UseCommandMiddleware appends middleware run around every command, the first
+// one being the outermost.
+func (a *Handler) UseCommandMiddleware(m ...Middleware) {
+	a.middleware = append(a.middleware, m...)
+}
+
+func (a *Handler) dispatch(s *session, req *dto.WebSocketRequest, payload []byte) {
+	ctx, res, _ := a.execute(s, req, payload)
+	if res == nil {
+		return
+	}
+	if err := ctx.Send(res); err != nil {
+		ctx.Logger.Error("Could not write message to websocket", "err", err)
+		return
+	}
+	if ctx.close != nil {
+		ctx.Logger.Info("closing websocket after reply", "code", ctx.close.Code, "reason", ctx.close.Text)
+		s.closeWith(ctx.close.Code, ctx.close.Text)
+		return
+	}
+	if res.Error == "" {
+		ctx.runAfter()
+	}
+}
+
+// execute runs a command through the middleware chain on behalf of s and
+// returns the reply to deliver, or nil when there is nothing to reply, along
+// with the error of the command.
+func (a *Handler) execute(s *session, req *dto.WebSocketRequest, payload []byte) (*CommandContext, *dto.WebsocketCommandResponse, error) {
+	ctx := &CommandContext{
+		Cmd:      req.Cmd,
+		ReqId:    req.ReqId,
+		PlayerId: req.Id,
+		Payload:  payload,
+		Logger:   s.logger.With("cmd", req.Cmd),
+		session:  s,
+	}
+	if len(req.Id) > 0 {
+		ctx.Logger = ctx.Logger.With("player", req.Id)
+	}
+	if len(req.ReqId) > 0 {
+		ctx.Logger = ctx.Logger.With("reqId", req.ReqId)
+	}
+	cmd, ok := a.commands[req.Cmd]
+	label := req.Cmd
+	if !ok {
+		cmd = a.unknownCommand
+		// Any cmd sent by a client would otherwise become a metric series.
+		label = "unknown"
+	}
+	for i := len(a.middleware) - 1; i >= 0; i-- {
+		cmd = a.middleware[i](cmd)
+	}
+
+	reply, err := cmd(ctx)
+	res := &dto.WebsocketCommandResponse{
+		Cmd:   req.Cmd,
+		ReqId: req.ReqId,
+		Reply: reply,
+		Data:  ctx.data,
+	}
+	if err != nil {
+		e := global.AsError(err)
+		res.Reply = ""
+		res.Error = e.Code()
+		res.Details = e.Details()
+		res.RetryAfterMs = int(e.RetryAfter().Round(time.Millisecond) / time.Millisecond)
+		metrics.CommandErrors.WithLabelValues(label, res.Error).Inc()
+	} else if len(reply) == 0 {
+		return ctx, nil, nil
+	}
+	return ctx, res, err
+}
+
+// unknownCommand answers commands with a missing or unregistered cmd with an
+// UNKNOWN_COMMAND error listing the supported commands.
+func (a *Handler) unknownCommand(ctx *CommandContext) (string, error) {
+	ctx.Logger.Warn("Not supported command")
+	ctx.SetData(dto.SupportedCommands{Commands: a.commandNames()})
+	return "", global.ErrUnknownCommand
+}
+
+func (a *Handler) commandNames() []string {
+	names := make([]string, 0, len(a.commands))
+	for name := range a.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *Handler) logCommand(next CommandFunc) CommandFunc {
+	return func(ctx *CommandContext) (string, error) {
+		start := time.Now()
+		reply, err := next(ctx)
+		if err != nil {
+			ctx.Logger.Warn("command failed", "err", err, "duration", time.Since(start))
+		} else {
+			ctx.Logger.Debug("command handled", "reply", reply, "duration", time.Since(start))
+		}
+		return reply, err
+	}
+}
+
+// authenticate binds a connection to the first player it successfully acted
+// for and rejects commands on behalf of any other player.
+func (a *Handler) authenticate(next CommandFunc) CommandFunc {
+	return func(ctx *CommandContext) (string, error) {
+		if player := ctx.session.player(); len(player) > 0 && len(ctx.PlayerId) > 0 && player != ctx.PlayerId {
+			return "", global.ErrUnauthorized
+		}
+		return next(ctx)
+	}
+}
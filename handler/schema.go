@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"net/http"
+	"rooms/dto"
+	"rooms/global"
+	"rooms/schema"
+	"strconv"
+)
+
+// commandRequests are the payloads of the built-in websocket commands.
+var commandRequests = map[string]any{
+	"hello":  dto.HelloRequest{},
+	"join":   dto.JoinRequest{},
+	"guess":  dto.GuessRequest{},
+	"leave":  dto.LeaveRequest{},
+	"chat":   dto.ChatRequest{},
+	"resume": dto.ResumeRequest{},
+}
+
+var events = []schema.Message{
+	{Name: "joinedRoom", Summary: "The player was matched into a room."},
+	{Name: "gameOver", Summary: "Every player of the room guessed, or the game was force finished."},
+	{Name: "serverShutdown", Summary: "The server is draining; running games end at the deadline."},
+	{Name: "roomClosed", Summary: "An operator closed the room; the game ends without trophies."},
+	{Name: "announcement", Summary: "A message of the operators to every connected session."},
+	{Name: "chatMessage", Summary: "A chat message of a player of the room."},
+}
+
+// OpenAPI serves the OpenAPI document of the HTTP endpoints.
+func (a *Handler) OpenAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, a.openAPI(), http.StatusOK)
+	})
+}
+
+// AsyncAPI serves the AsyncAPI document of the websocket commands and events.
+func (a *Handler) AsyncAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, a.asyncAPI(), http.StatusOK)
+	})
+}
+
+func (a *Handler) openAPI() schema.Schema {
+	c := schema.NewComponents()
+	reply := c.Ref(dto.WebsocketCommandResponse{})
+	moderation := c.Ref(dto.ModerationRequest{})
+	moderated := c.Ref(dto.ModerationResponse{})
+	endpoints := []schema.Endpoint{
+		{Method: "POST", Path: "/register", Summary: "Register a player", Request: c.Ref(dto.RegisterRequest{}), Status: http.StatusCreated, Response: c.Ref(dto.RegisterResponse{})},
+		{Method: "GET", Path: "/stats", Summary: "Registered players and active rooms", Status: http.StatusOK, Response: c.Ref(dto.StatsResponse{})},
+		{Method: "GET", Path: "/rooms/{id}/events", Summary: "Journal of a room", Status: http.StatusOK, Response: c.Ref(dto.RoomEventsResponse{})},
+		{Method: "GET", Path: "/seasons", Summary: "Seasons", Status: http.StatusOK, Response: c.Ref(dto.SeasonsResponse{})},
+		{Method: "GET", Path: "/seasons/{id}/leaderboard", Summary: "Leaderboard of a season", Status: http.StatusOK, Response: c.Ref(dto.LeaderboardResponse{})},
+		{Method: "POST", Path: "/players/{id}/join", Summary: "Join the waiting list", Request: c.Ref(dto.JoinRequest{}, "cmd", "id"), Status: http.StatusOK, Response: reply},
+		{Method: "POST", Path: "/players/{id}/guess", Summary: "Guess the secret of a room", Request: c.Ref(dto.GuessRequest{}, "cmd", "id"), Status: http.StatusOK, Response: reply},
+		{Method: "POST", Path: "/players/{id}/leave", Summary: "Leave the waiting list", Request: c.Ref(dto.LeaveRequest{}, "cmd", "id"), Status: http.StatusOK, Response: reply},
+		{Method: "POST", Path: "/players/{id}/chat", Summary: "Send a chat message to the room", Request: c.Ref(dto.ChatRequest{}, "cmd", "id"), Status: http.StatusOK, Response: reply},
+		{Method: "GET", Path: "/players/{id}/events", Summary: "Long-poll or stream the events of a player", Query: []string{"wait"}, Status: http.StatusOK, Response: c.Ref(dto.EventsResponse{})},
+		{Method: "GET", Path: "/healthz", Summary: "Liveness", Status: http.StatusOK, Response: c.Ref(dto.HealthResponse{})},
+		{Method: "GET", Path: "/readyz", Summary: "Readiness", Status: http.StatusOK, Response: c.Ref(dto.HealthResponse{})},
+		{Method: "GET", Path: "/metrics", Summary: "Prometheus metrics", Status: http.StatusOK, Response: schema.Schema{"type": "string"}, ContentType: "text/plain"},
+		{Method: "GET", Path: "/admin/sessions", Summary: "Connected sessions", Status: http.StatusOK, Response: c.Ref(dto.SessionsResponse{}), Auth: true},
+		{Method: "POST", Path: "/admin/sessions/{id}/kick", Summary: "Close a session", Request: moderation, Status: http.StatusOK, Response: moderated, Auth: true},
+		{Method: "GET", Path: "/admin/bans", Summary: "Bans", Status: http.StatusOK, Response: c.Ref(dto.BansResponse{}), Auth: true},
+		{Method: "PUT", Path: "/admin/bans/{kind}/{value}", Summary: "Ban a player id or nickname and close its sessions", Request: moderation, Status: http.StatusOK, Response: moderated, Auth: true},
+		{Method: "DELETE", Path: "/admin/bans/{kind}/{value}", Summary: "Remove a ban", Status: http.StatusOK, Response: moderated, Auth: true},
+		{Method: "POST", Path: "/admin/rooms/{id}/close", Summary: "End a game without trophies", Request: moderation, Status: http.StatusOK, Response: moderated, Auth: true},
+		{Method: "DELETE", Path: "/admin/waiting-list", Summary: "Clear the waiting list", Status: http.StatusOK, Response: moderated, Auth: true},
+		{Method: "POST", Path: "/admin/announcements", Summary: "Send an announcement to every session", Request: c.Ref(dto.AnnouncementRequest{}), Status: http.StatusOK, Response: moderated, Auth: true},
+	}
+	return schema.OpenAPI("rooms", strconv.Itoa(global.ProtocolVersion), c, c.Ref(dto.Error{}), endpoints)
+}
+
+func (a *Handler) asyncAPI() schema.Schema {
+	c := schema.NewComponents()
+	commands := make([]schema.Message, 0, len(a.commands))
+	for _, name := range a.commandNames() {
+		payload := schema.Schema{"type": "object"}
+		if req, ok := commandRequests[name]; ok {
+			payload = c.Ref(req)
+		}
+		commands = append(commands, schema.Message{Name: name, Summary: "The " + name + " command.", Payload: payload})
+	}
+	replies := []schema.Message{
+		{Name: "reply", Summary: "Reply or error of a command.", Payload: c.Ref(dto.WebsocketCommandResponse{})},
+	}
+	event := c.Ref(dto.WebsocketEventResponse{})
+	for _, e := range events {
+		e.Payload = schema.Schema{"allOf": []schema.Schema{event, {
+			"properties": schema.Schema{"event": schema.Schema{"const": e.Name}},
+			"required":   []string{"event"},
+		}}}
+		replies = append(replies, e)
+	}
+	return schema.AsyncAPI("rooms", strconv.Itoa(global.ProtocolVersion), "/websocket", c, commands, replies)
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// transport delivers the encoded messages of a session to its client.
+type transport interface {
+	write(messageType int, b []byte) error
+	writeClose(code int, reason string) error
+	close() error
+}
+
+type session struct {
+	id         string
+	conn       *websocket.Conn
+	transport  transport
+	logger     *slog.Logger
+	codec      Codec
+	writeMutex sync.Mutex
+	done       chan struct{}
+	closeOnce  sync.Once
+	playerId   string
+	roomId     string
+	watching   map[string]bool
+	mutex      sync.RWMutex
+}
+
+func newSession(t transport, codec Codec, logger *slog.Logger) *session {
+	id := uuid.New().String()
+	return &session{
+		id:        id,
+		transport: t,
+		logger:    logger.With("conn", id, "codec", codec.Name()),
+		codec:     codec,
+		done:      make(chan struct{}),
+		watching:  map[string]bool{},
+	}
+}
+
+func newWebsocketSession(conn *websocket.Conn, logger *slog.Logger, writeWait time.Duration) *session {
+	s := newSession(&websocketTransport{conn: conn, writeWait: writeWait}, codecFor(conn.Subprotocol()), logger)
+	s.conn = conn
+	return s
+}
+
+// send encodes v with the codec of the session and writes it.
+func (s *session) send(v any) error {
+	b, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.transport.write(s.codec.MessageType(), b)
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.transport.close()
+	})
+}
+
+// closeWith tells the client the close code before closing the session.
+func (s *session) closeWith(code int, reason string) {
+	if err := s.transport.writeClose(code, reason); err != nil {
+		s.logger.Warn("Could not write close message", "err", err)
+	}
+	s.close()
+}
+
+type websocketTransport struct {
+	conn      *websocket.Conn
+	writeWait time.Duration
+}
+
+func (t *websocketTransport) write(messageType int, b []byte) error {
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeWait))
+	return t.conn.WriteMessage(messageType, b)
+}
+
+func (t *websocketTransport) writeClose(code int, reason string) error {
+	msg := websocket.FormatCloseMessage(code, reason)
+	return t.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(t.writeWait))
+}
+
+func (t *websocketTransport) close() error {
+	return t.conn.Close()
+}
+
+func (s *session) setPlayer(id string) {
+	s.mutex.Lock()
+	s.playerId = id
+	s.mutex.Unlock()
+}
+
+func (s *session) setRoom(id string) {
+	s.mutex.Lock()
+	s.roomId = id
+	s.mutex.Unlock()
+}
+
+// watch marks key as watched and reports whether it was not watched before.
+func (s *session) watch(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.watching[key] {
+		return false
+	}
+	s.watching[key] = true
+	return true
+}
+
+func (s *session) unwatch(key string) {
+	s.mutex.Lock()
+	delete(s.watching, key)
+	s.mutex.Unlock()
+}
+
+func (s *session) player() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.playerId
+}
+
+func (s *session) room() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.roomId
+}
+
+func (a *Handler) addSession(s *session) {
+	a.sessionsMutex.Lock()
+	a.sessions[s.id] = s
+	a.sessionsMutex.Unlock()
+}
+
+func (a *Handler) removeSession(s *session) {
+	a.sessionsMutex.Lock()
+	delete(a.sessions, s.id)
+	a.sessionsMutex.Unlock()
+}
+
+func (a *Handler) allSessions() []*session {
+	a.sessionsMutex.Lock()
+	defer a.sessionsMutex.Unlock()
+	res := make([]*session, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		res = append(res, s)
+	}
+	return res
+}
+
+// keepAlive arms the read deadline of the session, extends it on every pong and
+// pings the client until the session is closed.
+func (a *Handler) keepAlive(s *session) {
+	s.conn.SetReadDeadline(time.Now().Add(a.pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(a.pongWait))
+	})
+	go func() {
+		ticker := time.NewTicker(a.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(a.writeWait))
+				if err != nil {
+					s.logger.Warn("Could not ping websocket, closing session", "err", err)
+					s.close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// teardown closes the session and takes its player out of the waiting list so
+// that dead connections are not matched into rooms.
+func (a *Handler) teardown(s *session) {
+	s.close()
+	a.removeSession(s)
+	a.connLimiter.Forget(s.id)
+	if player := s.player(); len(player) > 0 && len(s.room()) == 0 {
+		if err := a.service.Leave(player); err != nil {
+			s.logger.Warn("Could not remove player from waiting list", "player", player, "err", err)
+		}
+	}
+	s.logger.Info("Session closed.")
+}
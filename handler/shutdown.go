@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"rooms/dto"
+	"rooms/global"
+	"time"
+)
+
+// Shutdown stops accepting new games, announces the deadline to every connected
+// session, waits for the running rooms with a connected player to finish and
+// force-finishes the rest at the deadline before closing every websocket with a
+// close frame. Rooms without a connected player could only time out, so they
+// are not waited for.
+func (a *Handler) Shutdown(ctx context.Context, grace time.Duration) {
+	a.SetDraining()
+	deadline := time.Now().Add(grace)
+	a.logger.Info("draining websocket sessions", "deadline", deadline)
+	for _, s := range a.allSessions() {
+		a.send(s, &dto.WebsocketEventResponse{
+			Event:    "serverShutdown",
+			Deadline: &deadline,
+		})
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for len(a.playedRooms()) > 0 {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-timer.C:
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	for _, roomId := range a.runningRooms() {
+		res, err := a.service.ForceFinish(roomId)
+		if err != nil {
+			a.logger.Error("Could not force finish room", "room", roomId, "err", err)
+			continue
+		}
+		a.logger.Info("room force finished", "room", roomId)
+		event := gameOverResponse(res)
+		event.Reason = global.ServerShutdown
+		for _, s := range a.allSessions() {
+			for _, r := range res.Rankings {
+				if s.player() == r.Player.ID {
+					a.send(s, event)
+					break
+				}
+			}
+		}
+	}
+
+	for _, s := range a.allSessions() {
+		s.closeWith(websocket.CloseGoingAway, global.ServerShutdown)
+	}
+}
+
+// runningRooms returns the ids of the unfinished rooms, also those without a
+// connected player.
+func (a *Handler) runningRooms() []string {
+	rooms := a.service.RunningRooms()
+	res := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		res = append(res, room.ID)
+	}
+	return res
+}
+
+// playedRooms returns the ids of the unfinished rooms that have a connected
+// player.
+func (a *Handler) playedRooms() []string {
+	connected := map[string]bool{}
+	for _, s := range a.allSessions() {
+		connected[s.player()] = true
+	}
+	res := make([]string, 0)
+	for _, room := range a.service.RunningRooms() {
+		for _, p := range room.Players {
+			if connected[p.ID] {
+				res = append(res, room.ID)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func (a *Handler) send(s *session, v any) {
+	if err := s.send(v); err != nil {
+		s.logger.Error("Could not write message to websocket", "err", err)
+	}
+}
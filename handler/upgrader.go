@@ -3,10 +3,87 @@ package handler
 import (
 	"github.com/gorilla/websocket"
 	"net/http"
+	"rooms/metrics"
+	"strings"
+	"time"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+type upgraderOptions struct {
+	allowedOrigins   []string
+	readBufferSize   int
+	writeBufferSize  int
+	handshakeTimeout time.Duration
+	subprotocols     []string
+	compression      bool
+}
+
+func defaultUpgraderOptions() upgraderOptions {
+	return upgraderOptions{
+		allowedOrigins:  []string{"*"},
+		readBufferSize:  1024,
+		writeBufferSize: 1024,
+		subprotocols:    []string{"json", "msgpack"},
+	}
+}
+
+// WithAllowedOrigins restricts websocket upgrades to the given origins. "*"
+// allows every origin; requests without an Origin header are always allowed.
+func WithAllowedOrigins(origins []string) func(*Handler) {
+	return func(h *Handler) {
+		h.upgraderOptions.allowedOrigins = origins
+	}
+}
+
+func WithBufferSizes(read, write int) func(*Handler) {
+	return func(h *Handler) {
+		h.upgraderOptions.readBufferSize = read
+		h.upgraderOptions.writeBufferSize = write
+	}
+}
+
+func WithHandshakeTimeout(d time.Duration) func(*Handler) {
+	return func(h *Handler) {
+		h.upgraderOptions.handshakeTimeout = d
+	}
+}
+
+// WithSubprotocols sets the subprotocols offered during the upgrade. "json" and
+// "msgpack" select the codec of the connection; JSON is used when none matches.
+func WithSubprotocols(subprotocols []string) func(*Handler) {
+	return func(h *Handler) {
+		h.upgraderOptions.subprotocols = subprotocols
+	}
+}
+
+func WithCompression(enabled bool) func(*Handler) {
+	return func(h *Handler) {
+		h.upgraderOptions.compression = enabled
+	}
+}
+
+func (a *Handler) newUpgrader() *websocket.Upgrader {
+	o := a.upgraderOptions
+	return &websocket.Upgrader{
+		ReadBufferSize:    o.readBufferSize,
+		WriteBufferSize:   o.writeBufferSize,
+		HandshakeTimeout:  o.handshakeTimeout,
+		Subprotocols:      o.subprotocols,
+		EnableCompression: o.compression,
+		CheckOrigin:       a.checkOrigin,
+	}
+}
+
+func (a *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	for _, allowed := range a.upgraderOptions.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	a.logger.Warn("Websocket origin rejected", "origin", origin, "remote", r.RemoteAddr)
+	metrics.RejectedOrigins.Inc()
+	return false
 }
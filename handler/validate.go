@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"reflect"
+	"rooms/dto"
+	"strings"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// decodeCommand strictly decodes a websocket command into v, rejecting unknown
+// fields, mistyped values and missing required fields. The returned details
+// describe every offending field.
+func decodeCommand(codec Codec, b []byte, v any) ([]dto.FieldError, error) {
+	if err := codec.UnmarshalStrict(b, v); err != nil {
+		return decodeErrorDetails(err), err
+	}
+	if err := validate.Struct(v); err != nil {
+		var validationErrors validator.ValidationErrors
+		if !errors.As(err, &validationErrors) {
+			return nil, err
+		}
+		details := make([]dto.FieldError, 0, len(validationErrors))
+		for _, fe := range validationErrors {
+			details = append(details, dto.FieldError{
+				Field: fe.Field(),
+				Rule:  fe.Tag(),
+			})
+		}
+		return details, err
+	}
+	return nil, nil
+}
+
+func decodeErrorDetails(err error) []dto.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return []dto.FieldError{{Field: typeErr.Field, Rule: "type"}}
+	}
+	for _, prefix := range []string{"json: unknown field ", "msgpack: unknown field "} {
+		if msg := err.Error(); strings.HasPrefix(msg, prefix) {
+			field := strings.Trim(strings.TrimPrefix(msg, prefix), `"`)
+			return []dto.FieldError{{Field: field, Rule: "unknown"}}
+		}
+	}
+	return nil
+}
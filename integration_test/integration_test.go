@@ -1,10 +1,22 @@
 package integration_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"net/http"
+	"rooms/client"
 	"rooms/global"
+	"rooms/model"
+	"rooms/service"
+	"strings"
 	"time"
 
 	"rooms/dto"
@@ -79,6 +91,28 @@ func TestJoinedRoomEvent(t *testing.T) {
 
 	})
 }
+func TestJoinedRoomEventAgain(t *testing.T) {
+	Convey("JoinAgain", t, func(c C) {
+		ws, s := prepareJoinedRoomAgain(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("JoinedRoomEvent names the new room", func(c C) {
+			b, _ := json.Marshal(dto.JoinRequest{Cmd: "join", Id: "3"})
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			_, _, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+
+			ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+			res := dto.WebsocketEventResponse{}
+			err = ws.ReadJSON(&res)
+			c.So(err, ShouldBeNil)
+			c.So(res.Event, ShouldEqual, "joinedRoom")
+			c.So(res.Room, ShouldNotBeEmpty)
+			c.So(res.Room, ShouldNotBeIn, []string{"room0", "room1", "room2"})
+		})
+	})
+}
 func TestGuessCommand(t *testing.T) {
 	Convey("Guess", t, func(c C) {
 		ws, s := prepareGuess(c)
@@ -89,7 +123,7 @@ func TestGuessCommand(t *testing.T) {
 				Cmd:    "guess",
 				Id:     "2",
 				RoomId: "room1",
-				Data:   5,
+				Data:   intPtr(5),
 			}
 			b, _ := json.Marshal(guessReq)
 			err := ws.WriteMessage(websocket.TextMessage, b)
@@ -109,7 +143,7 @@ func TestGuessCommand(t *testing.T) {
 				Cmd:    "guess",
 				Id:     "5",
 				RoomId: "room1",
-				Data:   5,
+				Data:   intPtr(5),
 			}
 			b, _ := json.Marshal(guessReq)
 			err := ws.WriteMessage(websocket.TextMessage, b)
@@ -129,7 +163,7 @@ func TestGuessCommand(t *testing.T) {
 				Cmd:    "guess",
 				Id:     "4",
 				RoomId: "room1",
-				Data:   5,
+				Data:   intPtr(5),
 			}
 			b, _ := json.Marshal(guessReq)
 			err := ws.WriteMessage(websocket.TextMessage, b)
@@ -155,7 +189,7 @@ func TestGameOverEvent(t *testing.T) {
 				Cmd:    "guess",
 				Id:     "3",
 				RoomId: "room1",
-				Data:   3,
+				Data:   intPtr(3),
 			}
 			b, _ := json.Marshal(guessReq)
 			err := ws.WriteMessage(websocket.TextMessage, b)
@@ -189,3 +223,1476 @@ func TestGameOverEvent(t *testing.T) {
 
 	})
 }
+func TestRoomEvents(t *testing.T) {
+	Convey("RoomEvents", t, func(c C) {
+		ws, s, serv, repo := prepareRoomEvents(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("RoomEvents Successfully", func(c C) {
+			guessReq := dto.GuessRequest{
+				Cmd:    "guess",
+				Id:     "3",
+				RoomId: "room1",
+				Data:   intPtr(3),
+			}
+			b, _ := json.Marshal(guessReq)
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			_, _, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldContainSubstring, "gameOver")
+
+			resp, err := http.Get(s.URL + "/rooms/room1/events")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			res := dto.RoomEventsResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&res)
+			c.So(err, ShouldBeNil)
+			c.So(len(res.Events), ShouldEqual, 5)
+			c.So(res.Events[0].Type, ShouldEqual, global.RoomCreated)
+			c.So(res.Events[3].Type, ShouldEqual, global.GuessReceived)
+			c.So(res.Events[3].Player, ShouldEqual, "3")
+			c.So(res.Events[4].Type, ShouldEqual, global.GameFinished)
+			c.So(res.Events[4].Rankings[0].Player, ShouldEqual, "3")
+
+			result, err := serv.Replay("room1")
+			c.So(err, ShouldBeNil)
+			c.So(result.Rankings[0].Player.ID, ShouldEqual, "3")
+		})
+
+		Convey("Guesses after gameOver are rejected", func(c C) {
+			b, _ := json.Marshal(dto.GuessRequest{Cmd: "guess", Id: "3", RoomId: "room1", Data: intPtr(3)})
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			_, _, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldContainSubstring, "gameOver")
+
+			b, _ = json.Marshal(dto.GuessRequest{Cmd: "guess", Id: "3", RoomId: "room1", Data: intPtr(9)})
+			err = ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{}
+			err = ws.ReadJSON(&res)
+			c.So(err, ShouldBeNil)
+			c.So(res.Error, ShouldEqual, global.InvalidRequest)
+			events, err := serv.Events("room1")
+			c.So(err, ShouldBeNil)
+			c.So(len(events), ShouldEqual, 5)
+
+			// Events journaled after the game are not replayed.
+			repo.AppendEvent(model.RoomEvent{RoomID: "room1", Type: global.GuessReceived, PlayerID: "1", Guess: 3})
+			result, err := serv.Replay("room1")
+			c.So(err, ShouldBeNil)
+			c.So(result.Rankings[0].Player.ID, ShouldEqual, "3")
+		})
+
+		Convey("RoomEvents NotFound", func(c C) {
+			resp, err := http.Get(s.URL + "/rooms/room2/events")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+func TestSeasons(t *testing.T) {
+	Convey("Seasons", t, func(c C) {
+		s, serv := prepareSeasons(c)
+		defer s.Close()
+		Convey("EndSeason archives leaderboard and resets trophies", func(c C) {
+			_, err := serv.EndSeason()
+			c.So(err, ShouldBeNil)
+
+			resp, err := http.Get(s.URL + "/seasons")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			seasons := dto.SeasonsResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&seasons)
+			c.So(err, ShouldBeNil)
+			c.So(len(seasons.Seasons), ShouldEqual, 2)
+			c.So(seasons.Seasons[0].Current, ShouldBeFalse)
+			c.So(seasons.Seasons[1].Current, ShouldBeTrue)
+
+			resp, err = http.Get(s.URL + "/seasons/1/leaderboard")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			archived := dto.LeaderboardResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&archived)
+			c.So(err, ShouldBeNil)
+			c.So(archived.Leaderboard, ShouldResemble, []dto.LeaderboardEntry{
+				{Rank: 1, Player: "1", Nickname: "a", Trophies: 100},
+				{Rank: 2, Player: "2", Nickname: "b", Trophies: 40},
+			})
+
+			resp, err = http.Get(s.URL + "/seasons/2/leaderboard")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			current := dto.LeaderboardResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&current)
+			c.So(err, ShouldBeNil)
+			c.So(current.Leaderboard[0].Trophies, ShouldEqual, 50)
+			c.So(current.Leaderboard[1].Trophies, ShouldEqual, 20)
+		})
+
+		Convey("Leaderboard is read while players register", func(c C) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 200; i++ {
+					serv.Register(fmt.Sprintf("p%d", i))
+				}
+			}()
+			for reading := true; reading; {
+				select {
+				case <-done:
+					reading = false
+				default:
+					serv.Leaderboard()
+				}
+			}
+			c.So(len(serv.Leaderboard()), ShouldEqual, 202)
+		})
+
+		Convey("EndSeason while games finish", func(c C) {
+			serv := prepareSeasonGames(c, 100)
+			started, done := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					_, err := serv.ForceFinish(fmt.Sprintf("room%d", i))
+					c.So(err, ShouldBeNil)
+					if i == 0 {
+						close(started)
+					}
+				}
+			}()
+			<-started
+			_, err := serv.EndSeason()
+			c.So(err, ShouldBeNil)
+			<-done
+			// Each game came either before or after the reset, and kept its
+			// trophies.
+			for _, e := range serv.Leaderboard() {
+				if strings.HasPrefix(e.NickName, "a") {
+					c.So(e.Trophies, ShouldBeIn, []int{65, 80})
+				} else {
+					c.So(e.Trophies, ShouldBeIn, []int{30, 40})
+				}
+			}
+		})
+
+		Convey("Leaderboard of unknown season", func(c C) {
+			resp, err := http.Get(s.URL + "/seasons/9/leaderboard")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
+func TestHealth(t *testing.T) {
+	Convey("Health", t, func(c C) {
+		s, h, serv := prepareHealth(c)
+		defer s.Close()
+		Convey("Healthz Successfully", func(c C) {
+			resp, err := http.Get(s.URL + "/healthz")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Readyz before matchmaking", func(c C) {
+			resp, err := http.Get(s.URL + "/readyz")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+			res := dto.HealthResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&res)
+			c.So(err, ShouldBeNil)
+			c.So(res.Checks["matchmaker"].Status, ShouldEqual, "fail")
+			c.So(res.Checks["repository"].Status, ShouldEqual, "ok")
+		})
+
+		Convey("Readyz Successfully", func(c C) {
+			serv.CreateRooms()
+			resp, err := http.Get(s.URL + "/readyz")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Readyz while draining", func(c C) {
+			serv.CreateRooms()
+			h.SetDraining()
+			resp, err := http.Get(s.URL + "/readyz")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+}
+func TestMetrics(t *testing.T) {
+	Convey("Metrics", t, func(c C) {
+		s, serv := prepareMetrics(c)
+		defer s.Close()
+		scrape := func() string {
+			resp, err := http.Get(s.URL + "/metrics")
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			c.So(err, ShouldBeNil)
+			return string(b)
+		}
+
+		metrics := scrape()
+		c.So(metrics, ShouldContainSubstring, "\nrooms_active_rooms 1\n")
+		c.So(metrics, ShouldContainSubstring, "\nrooms_waiting_players 2\n")
+
+		_, err := serv.CloseRoom("running", "")
+		c.So(err, ShouldBeNil)
+		c.So(serv.Leave("4"), ShouldBeNil)
+		metrics = scrape()
+		c.So(metrics, ShouldContainSubstring, "\nrooms_active_rooms 0\n")
+		c.So(metrics, ShouldContainSubstring, "\nrooms_waiting_players 1\n")
+
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/websocket", nil)
+		c.So(err, ShouldBeNil)
+		defer ws.Close()
+		c.So(ws.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"bogus-123"}`)), ShouldBeNil)
+		_, _, err = ws.ReadMessage()
+		c.So(err, ShouldBeNil)
+		metrics = scrape()
+		c.So(metrics, ShouldNotContainSubstring, "bogus-123")
+		c.So(metrics, ShouldContainSubstring, `rooms_command_errors_total{cmd="unknown",code="UNKNOWN_COMMAND"}`)
+	})
+}
+func TestShutdown(t *testing.T) {
+	Convey("Shutdown", t, func(c C) {
+		ws, s, h, serv := prepareShutdown(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("Shutdown force finishes running rooms", func(c C) {
+			guessReq := dto.GuessRequest{
+				Cmd:    "guess",
+				Id:     "1",
+				RoomId: "room1",
+				Data:   intPtr(4),
+			}
+			b, _ := json.Marshal(guessReq)
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldContainSubstring, "guessReceived")
+
+			go h.Shutdown(context.Background(), time.Second)
+
+			_, p, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			shutdown := &dto.WebsocketEventResponse{}
+			err = json.Unmarshal(p, shutdown)
+			c.So(err, ShouldBeNil)
+			c.So(shutdown.Event, ShouldEqual, "serverShutdown")
+			c.So(shutdown.Deadline, ShouldNotBeNil)
+
+			_, p, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			gameOver := &dto.WebsocketEventResponse{}
+			err = json.Unmarshal(p, gameOver)
+			c.So(err, ShouldBeNil)
+			c.So(gameOver.Event, ShouldEqual, "gameOver")
+			c.So(gameOver.Reason, ShouldEqual, global.ServerShutdown)
+			c.So(gameOver.Rankings[0], ShouldResemble, dto.Ranking{Player: "1", Rank: 1, Guess: 4, DeltaTrophy: 30})
+			c.So(gameOver.Rankings[1].Rank, ShouldEqual, 3)
+			c.So(gameOver.Rankings[2].Rank, ShouldEqual, 3)
+
+			_, _, err = ws.ReadMessage()
+			c.So(websocket.IsCloseError(err, websocket.CloseGoingAway), ShouldBeTrue)
+			c.So(serv.Finished("room2"), ShouldBeTrue)
+		})
+
+		Convey("Rooms without a connected player are not waited for", func(c C) {
+			start := time.Now()
+			h.Shutdown(context.Background(), 10*time.Second)
+			c.So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+			c.So(serv.Finished("room1"), ShouldBeTrue)
+			c.So(serv.Finished("room2"), ShouldBeTrue)
+		})
+	})
+}
+func TestHeartbeat(t *testing.T) {
+	Convey("Heartbeat", t, func(c C) {
+		ws, s, repo := prepareHeartbeat(c)
+		defer ws.Close()
+		defer s.Close()
+		joinReq := dto.JoinRequest{
+			Cmd: "join",
+			Id:  "1",
+		}
+		b, _ := json.Marshal(joinReq)
+		err := ws.WriteMessage(websocket.TextMessage, b)
+		c.So(err, ShouldBeNil)
+		_, p, err := ws.ReadMessage()
+		c.So(err, ShouldBeNil)
+		c.So(string(p), ShouldContainSubstring, "waiting")
+		c.So(len(repo.GetWaitingListSnapshot()), ShouldEqual, 1)
+
+		Convey("Silent client is torn down", func(c C) {
+			time.Sleep(time.Second)
+			c.So(len(repo.GetWaitingListSnapshot()), ShouldEqual, 0)
+		})
+
+		Convey("Silent client is torn down after joining again", func(c C) {
+			guess := 3
+			b, _ := json.Marshal(dto.GuessRequest{Cmd: "guess", Id: "1", RoomId: "room1", Data: &guess})
+			c.So(ws.WriteMessage(websocket.TextMessage, b), ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldContainSubstring, "guessReceived")
+
+			b, _ = json.Marshal(joinReq)
+			c.So(ws.WriteMessage(websocket.TextMessage, b), ShouldBeNil)
+			_, p, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldContainSubstring, "waiting")
+			c.So(len(repo.GetWaitingListSnapshot()), ShouldEqual, 1)
+			time.Sleep(time.Second)
+			c.So(len(repo.GetWaitingListSnapshot()), ShouldEqual, 0)
+		})
+
+		Convey("Client answering pings stays connected", func(c C) {
+			go func() {
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+			time.Sleep(time.Second)
+			c.So(len(repo.GetWaitingListSnapshot()), ShouldEqual, 1)
+		})
+	})
+}
+func TestAllowedOrigins(t *testing.T) {
+	Convey("Origins", t, func(c C) {
+		s := prepareOrigins(c)
+		defer s.Close()
+		u := "ws" + strings.TrimPrefix(s.URL, "http")
+		Convey("Allowed origin is upgraded", func(c C) {
+			ws, _, err := websocket.DefaultDialer.Dial(u, http.Header{"Origin": {"https://game.example"}})
+			c.So(err, ShouldBeNil)
+			ws.Close()
+		})
+
+		Convey("Unknown origin is rejected", func(c C) {
+			_, resp, err := websocket.DefaultDialer.Dial(u, http.Header{"Origin": {"https://evil.example"}})
+			c.So(err, ShouldNotBeNil)
+			c.So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
+func TestRateLimit(t *testing.T) {
+	Convey("RateLimit", t, func(c C) {
+		ws, s := prepareRateLimit(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("Register is limited per IP", func(c C) {
+			resp, err := http.Post(s.URL+"/register", "application/json", strings.NewReader(`{"nickname":"a"}`))
+			c.So(err, ShouldBeNil)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+
+			resp, err = http.Post(s.URL+"/register", "application/json", strings.NewReader(`{"nickname":"b"}`))
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+			c.So(resp.Header.Get("Retry-After"), ShouldNotBeEmpty)
+			res := dto.Error{}
+			err = json.NewDecoder(resp.Body).Decode(&res)
+			c.So(err, ShouldBeNil)
+			c.So(res.Item, ShouldEqual, global.RateLimited)
+		})
+
+		Convey("Client gets rate limits as typed errors", func(c C) {
+			ctx := context.Background()
+			_, err := client.New(s.URL).Register(ctx, "a")
+			c.So(err, ShouldBeNil)
+			_, err = client.New(s.URL).Register(ctx, "b")
+			c.So(errors.Is(err, global.ErrRateLimited), ShouldBeTrue)
+			c.So(global.AsError(err).RetryAfter(), ShouldBeGreaterThan, 0)
+
+			err = client.New(s.URL + "/limited").Connect(ctx)
+			c.So(errors.Is(err, global.ErrRateLimited), ShouldBeTrue)
+			c.So(global.AsError(err).RetryAfter(), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("Websocket commands are limited per connection", func(c C) {
+			guessReq := dto.GuessRequest{
+				Cmd:    "guess",
+				Id:     "5",
+				RoomId: "room1",
+				Data:   intPtr(5),
+			}
+			b, _ := json.Marshal(guessReq)
+			for i := 0; i < 2; i++ {
+				err := ws.WriteMessage(websocket.TextMessage, b)
+				c.So(err, ShouldBeNil)
+				_, p, err := ws.ReadMessage()
+				c.So(err, ShouldBeNil)
+				c.So(string(p), ShouldContainSubstring, global.NotRegistered)
+			}
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{}
+			err = json.Unmarshal(p, &res)
+			c.So(err, ShouldBeNil)
+			c.So(res.Error, ShouldEqual, global.RateLimited)
+			c.So(res.RetryAfterMs, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("Other connections cannot drain the bucket of a player", func(c C) {
+			b, _ := json.Marshal(dto.GuessRequest{Cmd: "guess", Id: "1", RoomId: "room9", Data: intPtr(5)})
+			for i := 0; i < 2; i++ {
+				c.So(ws.WriteMessage(websocket.TextMessage, b), ShouldBeNil)
+				_, p, err := ws.ReadMessage()
+				c.So(err, ShouldBeNil)
+				c.So(string(p), ShouldContainSubstring, global.NotFoundErr)
+			}
+
+			player, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/websocket", nil)
+			c.So(err, ShouldBeNil)
+			defer player.Close()
+			b, _ = json.Marshal(dto.JoinRequest{Cmd: "join", Id: "1"})
+			c.So(player.WriteMessage(websocket.TextMessage, b), ShouldBeNil)
+			_, p, err := player.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldContainSubstring, "waiting")
+		})
+	})
+}
+func TestCommandValidation(t *testing.T) {
+	Convey("Validation", t, func(c C) {
+		ws, s := prepareGuess(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("Guess without data", func(c C) {
+			err := ws.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"guess","id":"2","roomId":"room1"}`))
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:     "guess",
+				Error:   global.InvalidParams,
+				Details: []dto.FieldError{{Field: "data", Rule: "required"}},
+			}
+			b, _ := json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+		})
+
+		Convey("Guess out of range", func(c C) {
+			for _, tc := range []struct {
+				guess int
+				rule  string
+			}{{global.SecretMin - 1, "min"}, {global.SecretMax + 1, "max"}} {
+				b, _ := json.Marshal(dto.GuessRequest{Cmd: "guess", Id: "2", RoomId: "room1", Data: intPtr(tc.guess)})
+				err := ws.WriteMessage(websocket.TextMessage, b)
+				c.So(err, ShouldBeNil)
+				res := dto.WebsocketCommandResponse{
+					Cmd:     "guess",
+					Error:   global.InvalidParams,
+					Details: []dto.FieldError{{Field: "data", Rule: tc.rule}},
+				}
+				b, _ = json.Marshal(res)
+				_, p, err := ws.ReadMessage()
+				c.So(err, ShouldBeNil)
+				c.So(string(p), ShouldEqual, string(b))
+			}
+
+			b, _ := json.Marshal(dto.GuessRequest{Cmd: "guess", Id: "2", RoomId: "room1", Data: intPtr(global.SecretMax)})
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldContainSubstring, "guessReceived")
+		})
+
+		Convey("Guess with unknown field", func(c C) {
+			err := ws.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"guess","id":"2","roomId":"room1","data":5,"user":"2"}`))
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:     "guess",
+				Error:   global.InvalidParams,
+				Details: []dto.FieldError{{Field: "user", Rule: "unknown"}},
+			}
+			b, _ := json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+		})
+
+		Convey("Oversized message closes the connection", func(c C) {
+			big := `{"cmd":"guess","id":"` + strings.Repeat("a", 8192) + `"}`
+			err := ws.WriteMessage(websocket.TextMessage, []byte(big))
+			c.So(err, ShouldBeNil)
+			_, _, err = ws.ReadMessage()
+			c.So(websocket.IsCloseError(err, websocket.CloseMessageTooBig), ShouldBeTrue)
+		})
+	})
+}
+func TestCommandRouter(t *testing.T) {
+	Convey("CommandRouter", t, func(c C) {
+		ws, s := prepareCommandRouter(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("Registered command is dispatched", func(c C) {
+			err := ws.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"echo","id":"1"}`))
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:   "echo",
+				Reply: "echo:1",
+			}
+			b, _ := json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+		})
+
+		Convey("Unknown command gets an error listing the supported commands", func(c C) {
+			for _, cmd := range []string{"jion", ""} {
+				b, _ := json.Marshal(dto.WebSocketRequest{Cmd: cmd, ReqId: "u1", Id: "1"})
+				err := ws.WriteMessage(websocket.TextMessage, b)
+				c.So(err, ShouldBeNil)
+				res := dto.WebsocketCommandResponse{
+					Cmd:   cmd,
+					ReqId: "u1",
+					Error: global.UnknownCommand,
+					Data:  dto.SupportedCommands{Commands: []string{"chat", "echo", "guess", "hello", "join", "leave", "resume"}},
+				}
+				b, _ = json.Marshal(res)
+				_, p, err := ws.ReadMessage()
+				c.So(err, ShouldBeNil)
+				c.So(string(p), ShouldEqual, string(b))
+			}
+		})
+
+		Convey("Connection is bound to its player", func(c C) {
+			b, _ := json.Marshal(dto.JoinRequest{Cmd: "join", Id: "1"})
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			_, _, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+
+			b, _ = json.Marshal(dto.JoinRequest{Cmd: "join", Id: "2"})
+			err = ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:   "join",
+				Error: global.Unauthorized,
+			}
+			b, _ = json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+		})
+	})
+}
+func TestRequestCorrelation(t *testing.T) {
+	Convey("RequestCorrelation", t, func(c C) {
+		ws, s, serv, repo := prepareDedup(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("ReqId is echoed in errors", func(c C) {
+			guessReq := dto.GuessRequest{
+				Cmd:    "guess",
+				ReqId:  "r0",
+				Id:     "9",
+				RoomId: "room1",
+				Data:   intPtr(5),
+			}
+			b, _ := json.Marshal(guessReq)
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:   "guess",
+				ReqId: "r0",
+				Error: global.NotRegistered,
+			}
+			b, _ = json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+		})
+
+		Convey("Retried reqId is applied once", func(c C) {
+			for _, data := range []int{5, 7} {
+				guessReq := dto.GuessRequest{
+					Cmd:    "guess",
+					ReqId:  "r1",
+					Id:     "1",
+					RoomId: "room1",
+					Data:   intPtr(data),
+				}
+				b, _ := json.Marshal(guessReq)
+				err := ws.WriteMessage(websocket.TextMessage, b)
+				c.So(err, ShouldBeNil)
+				res := dto.WebsocketCommandResponse{
+					Cmd:   "guess",
+					ReqId: "r1",
+					Reply: "guessReceived",
+				}
+				b, _ = json.Marshal(res)
+				_, p, err := ws.ReadMessage()
+				c.So(err, ShouldBeNil)
+				c.So(string(p), ShouldEqual, string(b))
+			}
+			events, err := serv.Events("room1")
+			c.So(err, ShouldBeNil)
+			c.So(len(events), ShouldEqual, 1)
+			c.So(events[0].Guess, ShouldEqual, 5)
+		})
+
+		Convey("Retried join over a new connection joins again", func(c C) {
+			join, _ := json.Marshal(dto.JoinRequest{Cmd: "join", ReqId: "j1", Id: "4"})
+			err := ws.WriteMessage(websocket.TextMessage, join)
+			c.So(err, ShouldBeNil)
+			_, _, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(waiting(repo, "4"), ShouldBeTrue)
+
+			// Teardown of the dropped connection takes the player off the list.
+			ws.Close()
+			for deadline := time.Now().Add(time.Second); waiting(repo, "4") && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+			}
+			c.So(waiting(repo, "4"), ShouldBeFalse)
+
+			u := "ws" + strings.TrimPrefix(s.URL, "http")
+			retry, _, err := websocket.DefaultDialer.Dial(u, nil)
+			c.So(err, ShouldBeNil)
+			defer retry.Close()
+			err = retry.WriteMessage(websocket.TextMessage, join)
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{}
+			err = retry.ReadJSON(&res)
+			c.So(err, ShouldBeNil)
+			c.So(res.Reply, ShouldEqual, "waiting")
+			c.So(waiting(repo, "4"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestHello(t *testing.T) {
+	Convey("Hello", t, func(c C) {
+		ws, s := prepareHello(c)
+		defer ws.Close()
+		defer s.Close()
+		capabilities := dto.HelloResponse{
+			Version:    global.ProtocolVersion,
+			MinVersion: global.MinProtocolVersion,
+			Commands:   []string{"chat", "guess", "hello", "join", "leave", "resume"},
+			Events:     []string{"joinedRoom", "gameOver", "serverShutdown", "roomClosed", "announcement", "chatMessage"},
+			GameModes:  []string{"classic"},
+			Rules: dto.Rules{
+				RoomSize:              3,
+				SecretMin:             1,
+				SecretMax:             10,
+				RatingSystem:          "elo",
+				MatchmakingIntervalMs: 30000,
+				PingIntervalMs:        25000,
+				PongWaitMs:            60000,
+				ChatMaxLength:         200,
+			},
+		}
+		Convey("Supported version is welcomed with the capabilities", func(c C) {
+			helloReq := dto.HelloRequest{
+				Cmd:     "hello",
+				ReqId:   "h1",
+				Version: intPtr(global.ProtocolVersion),
+			}
+			b, _ := json.Marshal(helloReq)
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:   "hello",
+				ReqId: "h1",
+				Reply: "welcome",
+				Data:  capabilities,
+			}
+			b, _ = json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+		})
+
+		Convey("Unsupported version is rejected and closed", func(c C) {
+			helloReq := dto.HelloRequest{
+				Cmd:     "hello",
+				Version: intPtr(global.ProtocolVersion + 1),
+			}
+			b, _ := json.Marshal(helloReq)
+			err := ws.WriteMessage(websocket.TextMessage, b)
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:   "hello",
+				Error: global.UnsupportedVer,
+				Data:  capabilities,
+			}
+			b, _ = json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+			_, _, err = ws.ReadMessage()
+			c.So(websocket.IsCloseError(err, global.CloseUnsupportedVersion), ShouldBeTrue)
+		})
+
+		Convey("Missing version is invalid", func(c C) {
+			err := ws.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"hello"}`))
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{
+				Cmd:     "hello",
+				Error:   global.InvalidParams,
+				Details: []dto.FieldError{{Field: "version", Rule: "required"}},
+			}
+			b, _ := json.Marshal(res)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(string(p), ShouldEqual, string(b))
+		})
+	})
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	Convey("MsgpackCodec", t, func(c C) {
+		ws, s := prepareCodec(c, "msgpack")
+		defer ws.Close()
+		defer s.Close()
+		c.So(ws.Subprotocol(), ShouldEqual, "msgpack")
+		Convey("Replies use msgpack binary frames", func(c C) {
+			joinReq := dto.JoinRequest{
+				Cmd:   "join",
+				ReqId: "m1",
+				Id:    "1",
+			}
+			err := ws.WriteMessage(websocket.BinaryMessage, encodeMsgpack(c, joinReq))
+			c.So(err, ShouldBeNil)
+			mt, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(mt, ShouldEqual, websocket.BinaryMessage)
+			res := dto.WebsocketCommandResponse{}
+			decodeMsgpack(c, p, &res)
+			c.So(res, ShouldResemble, dto.WebsocketCommandResponse{
+				Cmd:   "join",
+				ReqId: "m1",
+				Reply: "waiting",
+			})
+		})
+
+		Convey("Unknown fields are rejected", func(c C) {
+			joinReq := map[string]any{
+				"cmd":   "join",
+				"id":    "1",
+				"extra": true,
+			}
+			err := ws.WriteMessage(websocket.BinaryMessage, encodeMsgpack(c, joinReq))
+			c.So(err, ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			res := dto.WebsocketCommandResponse{}
+			decodeMsgpack(c, p, &res)
+			c.So(res, ShouldResemble, dto.WebsocketCommandResponse{
+				Cmd:     "join",
+				Error:   global.InvalidParams,
+				Details: []dto.FieldError{{Field: "extra", Rule: "unknown"}},
+			})
+		})
+	})
+
+	Convey("JsonCodec", t, func(c C) {
+		ws, s := prepareCodec(c, "json")
+		defer ws.Close()
+		defer s.Close()
+		c.So(ws.Subprotocol(), ShouldEqual, "json")
+		joinReq := dto.JoinRequest{
+			Cmd: "join",
+			Id:  "1",
+		}
+		b, _ := json.Marshal(joinReq)
+		err := ws.WriteMessage(websocket.TextMessage, b)
+		c.So(err, ShouldBeNil)
+		mt, p, err := ws.ReadMessage()
+		c.So(err, ShouldBeNil)
+		c.So(mt, ShouldEqual, websocket.TextMessage)
+		c.So(string(p), ShouldEqual, `{"cmd":"join","reply":"waiting"}`)
+	})
+}
+
+func encodeMsgpack(c C, v any) []byte {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	c.So(encoder.Encode(v), ShouldBeNil)
+	return buf.Bytes()
+}
+
+func decodeMsgpack(c C, b []byte, v any) {
+	decoder := msgpack.NewDecoder(bytes.NewReader(b))
+	decoder.SetCustomStructTag("json")
+	c.So(decoder.Decode(v), ShouldBeNil)
+}
+
+func TestPolling(t *testing.T) {
+	Convey("Polling", t, func(c C) {
+		s := preparePolling(c)
+		defer s.Close()
+		expected := &dto.WebsocketEventResponse{
+			Event:  "gameOver",
+			Secret: 3,
+			Rankings: []dto.Ranking{
+				{Player: "3", Rank: 1, Guess: 3, DeltaTrophy: 30},
+				{Player: "2", Rank: 2, Guess: 4, DeltaTrophy: 20},
+				{Player: "1", Rank: 3, Guess: 5, DeltaTrophy: 0},
+			},
+		}
+		gameOver, _ := json.Marshal(expected)
+
+		Convey("Join and leave over HTTP", func(c C) {
+			resp, err := http.Post(s.URL+"/players/4/join", "application/json", strings.NewReader(`{"reqId":"j1"}`))
+			c.So(err, ShouldBeNil)
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			c.So(string(b), ShouldEqual, `{"cmd":"join","reqId":"j1","reply":"waiting"}`)
+
+			resp, err = http.Post(s.URL+"/players/4/leave", "application/json", nil)
+			c.So(err, ShouldBeNil)
+			b, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			c.So(string(b), ShouldEqual, `{"cmd":"leave","reply":"left"}`)
+		})
+
+		Convey("Command errors map to HTTP statuses", func(c C) {
+			resp, err := http.Post(s.URL+"/players/9/join", "application/json", nil)
+			c.So(err, ShouldBeNil)
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			c.So(string(b), ShouldEqual, `{"cmd":"join","error":"NOT_REGISTERED"}`)
+
+			resp, err = http.Post(s.URL+"/players/3/guess", "application/json", strings.NewReader(`{"roomId":"room1"}`))
+			c.So(err, ShouldBeNil)
+			b, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			c.So(string(b), ShouldEqual, `{"cmd":"guess","error":"INVALID_PARAMS","details":[{"field":"data","rule":"required"}]}`)
+
+			resp, err = http.Get(s.URL + "/players/9/events")
+			c.So(err, ShouldBeNil)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Long-poll delivers the gameOver event", func(c C) {
+			resp, err := http.Post(s.URL+"/players/3/guess", "application/json", strings.NewReader(`{"roomId":"room1","data":3}`))
+			c.So(err, ShouldBeNil)
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			c.So(string(b), ShouldEqual, `{"cmd":"guess","reply":"guessReceived"}`)
+
+			resp, err = http.Get(s.URL + "/players/3/events?wait=5s")
+			c.So(err, ShouldBeNil)
+			res := dto.EventsResponse{}
+			err = json.NewDecoder(resp.Body).Decode(&res)
+			resp.Body.Close()
+			c.So(err, ShouldBeNil)
+			c.So(len(res.Events), ShouldEqual, 1)
+			c.So(string(res.Events[0]), ShouldEqual, string(gameOver))
+		})
+
+		Convey("Server-Sent Events deliver the gameOver event", func(c C) {
+			resp, err := http.Post(s.URL+"/players/3/guess", "application/json", strings.NewReader(`{"roomId":"room1","data":3}`))
+			c.So(err, ShouldBeNil)
+			resp.Body.Close()
+			c.So(resp.StatusCode, ShouldEqual, http.StatusOK)
+
+			req, _ := http.NewRequest(http.MethodGet, s.URL+"/players/3/events", nil)
+			req.Header.Set("Accept", "text/event-stream")
+			resp, err = http.DefaultClient.Do(req)
+			c.So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			c.So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			c.So(err, ShouldBeNil)
+			c.So(line, ShouldEqual, "data: "+string(gameOver)+"\n")
+		})
+	})
+}
+
+func TestErrors(t *testing.T) {
+	Convey("Errors", t, func(c C) {
+		ws, s, serv, _ := prepareDedup(c)
+		defer ws.Close()
+		defer s.Close()
+		Convey("Service errors match their kind", func(c C) {
+			err := serv.Join("9")
+			c.So(errors.Is(err, global.ErrNotRegistered), ShouldBeTrue)
+			c.So(errors.Is(err, global.ErrNotInRoom), ShouldBeFalse)
+			c.So(global.AsError(err).Code(), ShouldEqual, global.NotRegistered)
+			c.So(global.AsError(err).Status(), ShouldEqual, http.StatusNotFound)
+
+			err = serv.Guess("1", "room9", 3)
+			c.So(errors.Is(err, global.ErrNotFound), ShouldBeTrue)
+
+			_, err = serv.Replay("room1")
+			var e *global.Error
+			c.So(errors.As(err, &e), ShouldBeTrue)
+			c.So(e.Code(), ShouldEqual, global.NotFoundErr)
+		})
+
+		Convey("Foreign errors are internal", func(c C) {
+			err := global.AsError(fmt.Errorf("boom"))
+			c.So(err.Code(), ShouldEqual, global.Internal)
+			c.So(err.Status(), ShouldEqual, http.StatusInternalServerError)
+			c.So(errors.Is(err, global.ErrInternal), ShouldBeTrue)
+		})
+
+		Convey("Derived errors keep their kind", func(c C) {
+			err := fmt.Errorf("joining: %w", global.ErrRateLimited.WithRetryAfter(time.Second))
+			c.So(errors.Is(err, global.ErrRateLimited), ShouldBeTrue)
+			c.So(global.AsError(err).RetryAfter(), ShouldEqual, time.Second)
+			c.So(global.ErrRateLimited.RetryAfter(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestProtocolSchema(t *testing.T) {
+	Convey("ProtocolSchema", t, func(c C) {
+		s := prepareSchema(c)
+		defer s.Close()
+		Convey("HTTP responses match the OpenAPI document", func(c C) {
+			resp, err := http.Post(s.URL+"/register", "application/json", strings.NewReader(`{"nickname":"d"}`))
+			c.So(err, ShouldBeNil)
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			sch := compileSchema(c, s, "/openapi.json", "/paths/~1register/post/responses/201/content/application~1json/schema")
+			c.So(validateFrame(sch, b), ShouldBeNil)
+
+			resp, err = http.Get(s.URL + "/stats")
+			c.So(err, ShouldBeNil)
+			b, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			sch = compileSchema(c, s, "/openapi.json", "/components/schemas/StatsResponse")
+			c.So(validateFrame(sch, b), ShouldBeNil)
+
+			resp, err = http.Get(s.URL + "/players/9/events")
+			c.So(err, ShouldBeNil)
+			b, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			sch = compileSchema(c, s, "/openapi.json", "/components/schemas/Error")
+			c.So(validateFrame(sch, b), ShouldBeNil)
+		})
+
+		Convey("Websocket frames match the AsyncAPI document", func(c C) {
+			u := "ws" + strings.TrimPrefix(s.URL, "http") + "/websocket"
+			ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+			c.So(err, ShouldBeNil)
+			defer ws.Close()
+			reply := compileSchema(c, s, "/asyncapi.json", "/components/messages/reply/payload")
+
+			b, _ := json.Marshal(dto.HelloRequest{Cmd: "hello", Version: intPtr(global.ProtocolVersion)})
+			c.So(validateFrame(compileSchema(c, s, "/asyncapi.json", "/components/messages/hello/payload"), b), ShouldBeNil)
+			c.So(ws.WriteMessage(websocket.TextMessage, b), ShouldBeNil)
+			_, p, err := ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(validateFrame(reply, p), ShouldBeNil)
+
+			guess := compileSchema(c, s, "/asyncapi.json", "/components/messages/guess/payload")
+			c.So(validateFrame(guess, []byte(`{"cmd":"guess","user":"3","room":"room1","data":3}`)), ShouldNotBeNil)
+			c.So(validateFrame(guess, []byte(`{"cmd":"guess","id":"3","roomId":"room1","data":11}`)), ShouldNotBeNil)
+			b, _ = json.Marshal(dto.GuessRequest{Cmd: "guess", Id: "3", RoomId: "room1", Data: intPtr(3)})
+			c.So(validateFrame(guess, b), ShouldBeNil)
+			c.So(ws.WriteMessage(websocket.TextMessage, b), ShouldBeNil)
+			_, p, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(validateFrame(reply, p), ShouldBeNil)
+
+			_, p, err = ws.ReadMessage()
+			c.So(err, ShouldBeNil)
+			c.So(validateFrame(compileSchema(c, s, "/asyncapi.json", "/components/messages/gameOver/payload"), p), ShouldBeNil)
+			c.So(validateFrame(compileSchema(c, s, "/asyncapi.json", "/components/messages/joinedRoom/payload"), p), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestClient(t *testing.T) {
+	Convey("Client", t, func(c C) {
+		cl, s, dropper, repo := prepareClient(c)
+		defer s.Close()
+		defer cl.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.So(cl.Capabilities().Version, ShouldEqual, global.ProtocolVersion)
+
+		Convey("Register, join and leave", func(c C) {
+			id, err := cl.Register(ctx, "d")
+			c.So(err, ShouldBeNil)
+			c.So(id, ShouldNotBeEmpty)
+			c.So(cl.Join(ctx, id), ShouldBeNil)
+			c.So(cl.Leave(ctx, id), ShouldBeNil)
+		})
+
+		Convey("Error replies are typed", func(c C) {
+			err := cl.Join(ctx, "9")
+			c.So(errors.Is(err, global.ErrNotRegistered), ShouldBeTrue)
+			err = cl.Guess(ctx, "1", "room9", 3)
+			c.So(errors.Is(err, global.ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("GameOver is delivered as an event", func(c C) {
+			c.So(cl.Guess(ctx, "3", "room1", 3), ShouldBeNil)
+			select {
+			case e := <-cl.GameOver():
+				c.So(e.Secret, ShouldEqual, 3)
+				c.So(e.Rankings, ShouldResemble, []dto.Ranking{
+					{Player: "3", Rank: 1, Guess: 3, DeltaTrophy: 30},
+					{Player: "2", Rank: 2, Guess: 4, DeltaTrophy: 20},
+					{Player: "1", Rank: 3, Guess: 5, DeltaTrophy: 0},
+				})
+			case <-ctx.Done():
+				c.So(ctx.Err(), ShouldBeNil)
+			}
+		})
+
+		Convey("Waiting player is restored after a dropped connection", func(c C) {
+			c.So(cl.Join(ctx, "1"), ShouldBeNil)
+			dropper.drop()
+			time.Sleep(500 * time.Millisecond)
+			c.So(waiting(repo, "1"), ShouldBeTrue)
+
+			for _, id := range []string{"2", "3"} {
+				p, _ := repo.GetPlayerById(id)
+				p.JoinedAt = time.Now()
+				c.So(repo.Join(p), ShouldBeNil)
+			}
+			select {
+			case e := <-cl.JoinedRoom():
+				c.So(e.Room, ShouldNotBeIn, []string{"room0", "room1"})
+				room, err := repo.GetRoomById(e.Room)
+				c.So(err, ShouldBeNil)
+				c.So(len(room.Players), ShouldEqual, 3)
+			case <-ctx.Done():
+				c.So(ctx.Err(), ShouldBeNil)
+			}
+		})
+	})
+}
+func TestBots(t *testing.T) {
+	Convey("Bots", t, func(c C) {
+		Convey("Waiting players are not matched with bots before the threshold", func(c C) {
+			s, serv := prepareBots(c, time.Hour)
+			defer s.Close()
+			c.So(serv.CreateRooms(), ShouldBeEmpty)
+		})
+
+		Convey("Bots fill the room after the threshold and are kept off the leaderboard", func(c C) {
+			s, serv := prepareBots(c, time.Millisecond)
+			defer s.Close()
+			time.Sleep(5 * time.Millisecond)
+			guesses := guessLatencyCount(c)
+			rooms := serv.CreateRooms()
+			c.So(len(rooms), ShouldEqual, 1)
+			c.So(guessLatencyCount(c), ShouldEqual, guesses)
+			roomId := ""
+			for id := range rooms {
+				roomId = id
+			}
+
+			events := dto.RoomEventsResponse{}
+			resp, err := http.Get(s.URL + "/rooms/" + roomId + "/events")
+			c.So(err, ShouldBeNil)
+			err = json.NewDecoder(resp.Body).Decode(&events)
+			resp.Body.Close()
+			c.So(err, ShouldBeNil)
+			c.So(len(events.Events), ShouldEqual, 3)
+			c.So(events.Events[0].Players, ShouldContain, "1")
+			c.So(len(events.Events[0].Bots), ShouldEqual, 2)
+			c.So(events.Events[0].Bots, ShouldNotContain, "1")
+			for _, e := range events.Events[1:] {
+				c.So(e.Type, ShouldEqual, global.GuessReceived)
+				c.So(events.Events[0].Bots, ShouldContain, e.Player)
+				c.So(*e.Guess, ShouldEqual, 5)
+			}
+
+			bot := events.Events[0].Bots[0]
+			for _, nickName := range []string{"bot-midpoint-1", "Bot-me"} {
+				_, err = serv.Register(nickName)
+				c.So(errors.Is(err, global.ErrInvalidParams), ShouldBeTrue)
+			}
+			c.So(errors.Is(serv.Join(bot), global.ErrUnauthorized), ShouldBeTrue)
+			c.So(errors.Is(serv.Guess(bot, roomId, 1), global.ErrUnauthorized), ShouldBeTrue)
+			_, err = serv.Chat(bot, roomId, "hi")
+			c.So(errors.Is(err, global.ErrUnauthorized), ShouldBeTrue)
+
+			c.So(serv.Guess("1", roomId, 1), ShouldBeNil)
+			serv.GameOver(roomId)
+			resp, err = http.Get(s.URL + "/rooms/" + roomId + "/events")
+			c.So(err, ShouldBeNil)
+			err = json.NewDecoder(resp.Body).Decode(&events)
+			resp.Body.Close()
+			c.So(err, ShouldBeNil)
+			gameOver := events.Events[len(events.Events)-1]
+			c.So(gameOver.Type, ShouldEqual, global.GameFinished)
+			bots := 0
+			for _, r := range gameOver.Rankings {
+				c.So(r.Bot, ShouldEqual, r.Player != "1")
+				if r.Bot {
+					bots++
+				}
+			}
+			c.So(bots, ShouldEqual, 2)
+
+			stats := dto.StatsResponse{}
+			resp, err = http.Get(s.URL + "/stats")
+			c.So(err, ShouldBeNil)
+			err = json.NewDecoder(resp.Body).Decode(&stats)
+			resp.Body.Close()
+			c.So(err, ShouldBeNil)
+			c.So(stats.RegisteredPlayers, ShouldEqual, 1)
+
+			leaderboard := dto.LeaderboardResponse{}
+			resp, err = http.Get(s.URL + "/seasons/1/leaderboard")
+			c.So(err, ShouldBeNil)
+			err = json.NewDecoder(resp.Body).Decode(&leaderboard)
+			resp.Body.Close()
+			c.So(err, ShouldBeNil)
+			c.So(len(leaderboard.Leaderboard), ShouldEqual, 1)
+			c.So(leaderboard.Leaderboard[0].Player, ShouldEqual, "1")
+
+			Convey("Idle bots are reused", func(c C) {
+				c.So(serv.Join("1"), ShouldBeNil)
+				time.Sleep(5 * time.Millisecond)
+				rooms := serv.CreateRooms()
+				c.So(len(rooms), ShouldEqual, 2)
+				for id, room := range rooms {
+					if id == roomId {
+						continue
+					}
+					for _, p := range room.Players {
+						if p.ID != "1" {
+							c.So(events.Events[0].Bots, ShouldContain, p.ID)
+						}
+					}
+				}
+			})
+		})
+
+		Convey("Strategies", func(c C) {
+			c.So(service.NewBotStrategy("midpoint").Guess(1, 10), ShouldEqual, 5)
+			c.So(service.NewBotStrategy("unknown").Name(), ShouldEqual, "random")
+			for i := 0; i < 20; i++ {
+				g := service.NewBotStrategy("random").Guess(1, 10)
+				c.So(g, ShouldBeBetweenOrEqual, 1, 10)
+			}
+			adaptive := service.NewBotStrategy("adaptive")
+			c.So(adaptive.Guess(1, 10), ShouldEqual, 5)
+			adaptive.Observe(3)
+			adaptive.Observe(4)
+			c.So(adaptive.Guess(1, 10), ShouldEqual, 4)
+		})
+	})
+}
+func TestAdmin(t *testing.T) {
+	Convey("Admin", t, func(c C) {
+		admin, s, serv, repo := prepareAdmin(c)
+		defer s.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		Convey("Admin endpoints need the token", func(c C) {
+			_, err := client.New(s.URL).Sessions(ctx)
+			c.So(errors.Is(err, global.ErrUnauthorized), ShouldBeTrue)
+			_, err = client.New(s.URL, client.WithAdminToken("wrong")).Announce(ctx, "hi")
+			c.So(errors.Is(err, global.ErrUnauthorized), ShouldBeTrue)
+		})
+
+		Convey("Kick closes a session and the client stops reconnecting", func(c C) {
+			player := connectClient(c, s)
+			defer player.Close()
+			c.So(player.Join(ctx, "4"), ShouldBeNil)
+			sessions, err := admin.Sessions(ctx)
+			c.So(err, ShouldBeNil)
+			c.So(len(sessions.Sessions), ShouldEqual, 1)
+			c.So(sessions.Sessions[0].Player, ShouldEqual, "4")
+			c.So(sessions.Sessions[0].Transport, ShouldEqual, "websocket")
+
+			res, err := admin.Kick(ctx, sessions.Sessions[0].Id, "spam")
+			c.So(err, ShouldBeNil)
+			c.So(res.Players, ShouldResemble, []string{"4"})
+			select {
+			case _, ok := <-player.JoinedRoom():
+				c.So(ok, ShouldBeFalse)
+			case <-ctx.Done():
+				c.So(ctx.Err(), ShouldBeNil)
+			}
+			c.So(player.Join(ctx, "4"), ShouldEqual, client.ErrClosed)
+
+			_, err = admin.Kick(ctx, "unknown", "")
+			c.So(errors.Is(err, global.ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("Banned players and nicknames cannot play", func(c C) {
+			player := connectClient(c, s)
+			defer player.Close()
+			c.So(player.Join(ctx, "4"), ShouldBeNil)
+
+			res, err := admin.Ban(ctx, global.BanPlayer, "4", "cheating")
+			c.So(err, ShouldBeNil)
+			c.So(res.Sessions, ShouldEqual, 1)
+			c.So(waiting(repo, "4"), ShouldBeFalse)
+			select {
+			case _, ok := <-player.JoinedRoom():
+				c.So(ok, ShouldBeFalse)
+			case <-ctx.Done():
+				c.So(ctx.Err(), ShouldBeNil)
+			}
+			_, err = admin.Register(ctx, "n4")
+			c.So(errors.Is(err, global.ErrBanned), ShouldBeTrue)
+			c.So(errors.Is(serv.Join("4"), global.ErrBanned), ShouldBeTrue)
+
+			_, err = admin.Ban(ctx, global.BanNickname, "troll", "")
+			c.So(err, ShouldBeNil)
+			_, err = admin.Register(ctx, "troll")
+			c.So(errors.Is(err, global.ErrBanned), ShouldBeTrue)
+			_, err = admin.Ban(ctx, "ip", "1.2.3.4", "")
+			c.So(errors.Is(err, global.ErrInvalidParams), ShouldBeTrue)
+
+			bans, err := admin.Bans(ctx)
+			c.So(err, ShouldBeNil)
+			c.So(len(bans.Bans), ShouldEqual, 2)
+			c.So(bans.Bans[0].Value, ShouldEqual, "4")
+			c.So(bans.Bans[0].Reason, ShouldEqual, "cheating")
+
+			c.So(admin.Unban(ctx, global.BanPlayer, "4"), ShouldBeNil)
+			c.So(serv.Join("4"), ShouldBeNil)
+			err = admin.Unban(ctx, global.BanPlayer, "4")
+			c.So(errors.Is(err, global.ErrNotFound), ShouldBeTrue)
+		})
+
+		Convey("Closing a room ends its game without trophies", func(c C) {
+			player := connectClient(c, s)
+			defer player.Close()
+			c.So(player.Guess(ctx, "1", "room1", 4), ShouldBeNil)
+
+			res, err := admin.CloseRoom(ctx, "room1", "stuck")
+			c.So(err, ShouldBeNil)
+			c.So(res.Sessions, ShouldEqual, 1)
+			c.So(res.Players, ShouldResemble, []string{"1", "2", "3"})
+			select {
+			case e := <-player.RoomClosed():
+				c.So(e, ShouldResemble, client.RoomClosed{Room: "room1", Reason: "stuck"})
+			case <-ctx.Done():
+				c.So(ctx.Err(), ShouldBeNil)
+			}
+
+			events, err := admin.RoomEvents(ctx, "room1")
+			c.So(err, ShouldBeNil)
+			last := events.Events[len(events.Events)-1]
+			c.So(last.Type, ShouldEqual, global.RoomClosed)
+			c.So(last.Reason, ShouldEqual, "stuck")
+			c.So(errors.Is(serv.Guess("2", "room1", 3), global.ErrNotFound), ShouldBeTrue)
+			p, _ := repo.GetPlayerById("1")
+			c.So(p.Trophies, ShouldEqual, 0)
+
+			_, err = admin.CloseRoom(ctx, "room1", "")
+			c.So(errors.Is(err, global.ErrInvalidRequest), ShouldBeTrue)
+		})
+
+		Convey("Clear the waiting list", func(c C) {
+			c.So(serv.Join("4"), ShouldBeNil)
+			c.So(serv.Join("5"), ShouldBeNil)
+			res, err := admin.ClearWaitingList(ctx)
+			c.So(err, ShouldBeNil)
+			c.So(res.Players, ShouldHaveLength, 2)
+			c.So(repo.GetWaitingListSnapshot(), ShouldBeEmpty)
+		})
+
+		Convey("Waiting list is cleared while players join and leave", func(c C) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 200; i++ {
+					serv.Join("4")
+					serv.Leave("4")
+				}
+			}()
+			for clearing := true; clearing; {
+				select {
+				case <-done:
+					clearing = false
+				default:
+					serv.ClearWaitingList()
+					serv.CreateRooms()
+				}
+			}
+			serv.ClearWaitingList()
+			c.So(repo.GetWaitingListSnapshot(), ShouldBeEmpty)
+		})
+
+		Convey("Announcements reach every session", func(c C) {
+			p1, p2 := connectClient(c, s), connectClient(c, s)
+			defer p1.Close()
+			defer p2.Close()
+			res, err := admin.Announce(ctx, "maintenance at noon")
+			c.So(err, ShouldBeNil)
+			c.So(res.Sessions, ShouldEqual, 2)
+			for _, p := range []*client.Client{p1, p2} {
+				select {
+				case e := <-p.Announcement():
+					c.So(e.Message, ShouldEqual, "maintenance at noon")
+					c.So(e.Time.IsZero(), ShouldBeFalse)
+				case <-ctx.Done():
+					c.So(ctx.Err(), ShouldBeNil)
+				}
+			}
+			_, err = admin.Announce(ctx, "")
+			c.So(errors.Is(err, global.ErrInvalidParams), ShouldBeTrue)
+		})
+	})
+}
+func TestChat(t *testing.T) {
+	Convey("Chat", t, func(c C) {
+		s, dropper := prepareChat(c)
+		defer s.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p1, p2 := connectClient(c, s), connectClient(c, s)
+		defer p1.Close()
+		defer p2.Close()
+		c.So(p2.Resume(ctx, "2", "room1"), ShouldBeNil)
+		receive := func(cl *client.Client) client.ChatMessage {
+			select {
+			case m := <-cl.ChatMessage():
+				return m
+			case <-ctx.Done():
+				c.So(ctx.Err(), ShouldBeNil)
+				return client.ChatMessage{}
+			}
+		}
+
+		Convey("Messages reach every player of the room", func(c C) {
+			other := connectClient(c, s)
+			defer other.Close()
+			c.So(other.Join(ctx, "4"), ShouldBeNil)
+
+			c.So(p1.Chat(ctx, "1", "room1", " hi all "), ShouldBeNil)
+			for _, cl := range []*client.Client{p1, p2} {
+				m := receive(cl)
+				c.So(m.Room, ShouldEqual, "room1")
+				c.So(m.Player, ShouldEqual, "1")
+				c.So(m.Nickname, ShouldEqual, "n1")
+				c.So(m.Message, ShouldEqual, "hi all")
+				c.So(m.Time.IsZero(), ShouldBeFalse)
+			}
+			select {
+			case m := <-other.ChatMessage():
+				c.So(m, ShouldBeZeroValue)
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			err := other.Chat(ctx, "4", "room1", "let me in")
+			c.So(errors.Is(err, global.ErrNotInRoom), ShouldBeTrue)
+		})
+
+		Convey("Messages are checked and filtered", func(c C) {
+			c.So(p1.Chat(ctx, "1", "room1", "oh DARN it"), ShouldBeNil)
+			c.So(receive(p1).Message, ShouldEqual, "oh **** it")
+			c.So(receive(p2).Message, ShouldEqual, "oh **** it")
+			for _, m := range []struct{ sent, received string }{
+				{"ŞAPŞAL!", "******!"},
+				{"aptalı mı", "aptalı mı"},
+				{"darn darn", "**** ****"},
+			} {
+				c.So(p2.Chat(ctx, "2", "room1", m.sent), ShouldBeNil)
+				c.So(receive(p2).Message, ShouldEqual, m.received)
+			}
+
+			err := p1.Chat(ctx, "1", "room1", "much too long")
+			c.So(errors.Is(err, global.ErrInvalidParams), ShouldBeTrue)
+			c.So(global.AsError(err).Details(), ShouldResemble, []dto.FieldError{{Field: "message", Rule: "max"}})
+			err = p1.Chat(ctx, "1", "room1", "   ")
+			c.So(errors.Is(err, global.ErrInvalidParams), ShouldBeTrue)
+		})
+
+		Convey("Messages are rate limited per player", func(c C) {
+			other := connectClient(c, s)
+			defer other.Close()
+			for i := 0; i < 3; i++ {
+				err := other.Chat(ctx, "1", "room1", " ")
+				c.So(errors.Is(err, global.ErrInvalidParams), ShouldBeTrue)
+			}
+
+			c.So(p1.Resume(ctx, "1", "room1"), ShouldBeNil)
+			for i := 0; i < 3; i++ {
+				c.So(p1.Chat(ctx, "1", "room1", "spam"), ShouldBeNil)
+			}
+			err := p1.Chat(ctx, "1", "room1", "spam")
+			c.So(errors.Is(err, global.ErrRateLimited), ShouldBeTrue)
+			c.So(global.AsError(err).RetryAfter(), ShouldBeGreaterThan, 0)
+			c.So(p2.Chat(ctx, "2", "room1", "not me"), ShouldBeNil)
+		})
+
+		Convey("Resume delivers the backlog of the room", func(c C) {
+			for _, m := range []string{"a", "b"} {
+				c.So(p1.Chat(ctx, "1", "room1", m), ShouldBeNil)
+				c.So(p2.Chat(ctx, "2", "room1", m), ShouldBeNil)
+			}
+			p3 := connectClient(c, s)
+			defer p3.Close()
+			c.So(p3.Resume(ctx, "3", "room1"), ShouldBeNil)
+			backlog := []string{}
+			for i := 0; i < 3; i++ {
+				m := receive(p3)
+				backlog = append(backlog, m.Player+":"+m.Message)
+			}
+			c.So(backlog, ShouldResemble, []string{"2:a", "1:b", "2:b"})
+
+			p4 := connectClient(c, s)
+			defer p4.Close()
+			err := p4.Resume(ctx, "4", "room1")
+			c.So(errors.Is(err, global.ErrNotInRoom), ShouldBeTrue)
+		})
+
+		Convey("A negative backlog keeps no messages", func(c C) {
+			serv := prepareChatBacklog(c, -1)
+			_, err := serv.Chat("1", "room1", "hi")
+			c.So(err, ShouldBeNil)
+			backlog, err := serv.ChatBacklog("1", "room1")
+			c.So(err, ShouldBeNil)
+			c.So(backlog, ShouldBeEmpty)
+		})
+
+		Convey("Messages missed while reconnecting are delivered once", func(c C) {
+			p1 := client.New(s.URL+"/dropped", client.WithReconnect(50*time.Millisecond, time.Second))
+			c.So(p1.Connect(ctx), ShouldBeNil)
+			defer p1.Close()
+			c.So(p1.Guess(ctx, "1", "room1", 4), ShouldBeNil)
+			c.So(p2.Chat(ctx, "2", "room1", "before"), ShouldBeNil)
+			c.So(receive(p1).Message, ShouldEqual, "before")
+
+			dropper.drop()
+			c.So(p2.Chat(ctx, "2", "room1", "during"), ShouldBeNil)
+			c.So(receive(p1).Message, ShouldEqual, "during")
+			select {
+			case m := <-p1.ChatMessage():
+				c.So(m, ShouldBeZeroValue)
+			case <-time.After(300 * time.Millisecond):
+			}
+		})
+	})
+}
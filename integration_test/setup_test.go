@@ -1,15 +1,29 @@
 package integration_test
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	. "github.com/smartystreets/goconvey/convey"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"rooms/client"
+	"rooms/dto"
+	"rooms/global"
 	"rooms/handler"
+	"rooms/metrics"
 	"rooms/model"
+	"rooms/ratelimit"
 	"rooms/repo"
 	"rooms/service"
 	"strings"
+	"sync"
+	"time"
 )
 
 func prepareJoin(c C) (*websocket.Conn, *httptest.Server) {
@@ -72,6 +86,48 @@ func prepareJoinedRoom(c C) (*websocket.Conn, *httptest.Server) {
 	c.So(err, ShouldBeNil)
 	return ws, s
 }
+func prepareJoinedRoomAgain(c C) (*websocket.Conn, *httptest.Server) {
+	players := map[string]*model.Player{}
+	for _, id := range []string{"1", "2", "3"} {
+		players[id] = &model.Player{
+			ID:       id,
+			NickName: "n" + id,
+			Guess:    -1,
+		}
+	}
+	all := []*model.Player{players["1"], players["2"], players["3"]}
+	// The players already played together in finished rooms.
+	rooms := map[string]*model.Room{}
+	journal := map[string][]model.RoomEvent{}
+	for i, id := range []string{"room0", "room1", "room2"} {
+		rooms[id] = &model.Room{
+			ID:        id,
+			Players:   all,
+			Secret:    3,
+			CreatedAt: time.Now().Add(time.Duration(i-10) * time.Minute),
+		}
+		journal[id] = []model.RoomEvent{{Seq: 1, RoomID: id, Type: global.GameFinished}}
+	}
+	wl := map[string]*model.Player{}
+	wl["1"] = players["1"]
+	wl["2"] = players["2"]
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(wl),
+		repo.WithJournal(journal),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithMatchmakingInterval(50*time.Millisecond),
+	)
+	s := httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s
+}
 func prepareGuess(c C) (*websocket.Conn, *httptest.Server) {
 	p1 := &model.Player{
 		ID:       "1",
@@ -189,3 +245,763 @@ func prepareGameOver(c C) (*websocket.Conn, *httptest.Server) {
 	c.So(err, ShouldBeNil)
 	return ws, s
 }
+func prepareRoomEvents(c C) (*websocket.Conn, *httptest.Server, *service.Service, repo.Repo) {
+	p1 := &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Guess:    5,
+		Diff:     2,
+	}
+	p2 := &model.Player{
+		ID:       "2",
+		NickName: "b",
+		Guess:    4,
+		Diff:     1,
+	}
+	p3 := &model.Player{
+		ID:       "3",
+		NickName: "c",
+		Guess:    -1,
+	}
+	players := map[string]*model.Player{}
+	players["1"] = p1
+	players["2"] = p2
+	players["3"] = p3
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{p1, p2, p3},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+		repo.WithJournal(map[string][]model.RoomEvent{}),
+	)
+	repo.AppendEvent(model.RoomEvent{RoomID: "room1", Type: global.RoomCreated, Players: []string{"1", "2", "3"}, Secret: 3})
+	repo.AppendEvent(model.RoomEvent{RoomID: "room1", Type: global.GuessReceived, PlayerID: "1", Guess: 5})
+	repo.AppendEvent(model.RoomEvent{RoomID: "room1", Type: global.GuessReceived, PlayerID: "2", Guess: 4})
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv))
+	router := mux.NewRouter()
+	router.Handle("/rooms/{id}/events", handler.RoomEvents()).Methods("GET")
+	router.Handle("/websocket", handler.Websocket())
+	s := httptest.NewServer(router)
+	u := "ws" + strings.TrimPrefix(s.URL, "http") + "/websocket"
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s, serv, repo
+}
+func prepareSeasons(c C) (*httptest.Server, *service.Service) {
+	players := map[string]*model.Player{}
+	players["1"] = &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Trophies: 100,
+	}
+	players["2"] = &model.Player{
+		ID:       "2",
+		NickName: "b",
+		Trophies: 40,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	_, err := serv.CurrentSeason()
+	c.So(err, ShouldBeNil)
+	handler := handler.NewHandler(handler.WithService(serv))
+	router := mux.NewRouter()
+	router.Handle("/seasons", handler.Seasons()).Methods("GET")
+	router.Handle("/seasons/{id}/leaderboard", handler.SeasonLeaderboard()).Methods("GET")
+	return httptest.NewServer(router), serv
+}
+
+// prepareSeasonGames returns a service with n running rooms. In each room
+// player a<i> with 100 trophies wins against player b<i> with 40 trophies.
+func prepareSeasonGames(c C, n int) *service.Service {
+	players := map[string]*model.Player{}
+	rooms := map[string]*model.Room{}
+	for i := 0; i < n; i++ {
+		a := &model.Player{ID: fmt.Sprintf("a%d", i), NickName: fmt.Sprintf("a%d", i), Trophies: 100, Guess: 3}
+		b := &model.Player{ID: fmt.Sprintf("b%d", i), NickName: fmt.Sprintf("b%d", i), Trophies: 40, Guess: 5, Diff: 2}
+		players[a.ID], players[b.ID] = a, b
+		id := fmt.Sprintf("room%d", i)
+		rooms[id] = &model.Room{ID: id, Players: []*model.Player{a, b}, Secret: 3}
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	_, err := serv.CurrentSeason()
+	c.So(err, ShouldBeNil)
+	return serv
+}
+
+// prepareMetrics registers the repo gauges, which can only happen once per
+// test binary.
+func prepareMetrics(c C) (*httptest.Server, *service.Service) {
+	players := map[string]*model.Player{}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		players[id] = &model.Player{
+			ID:       id,
+			NickName: "n" + id,
+			Guess:    -1,
+		}
+	}
+	rooms := map[string]*model.Room{}
+	journal := map[string][]model.RoomEvent{}
+	for _, id := range []string{"running", "finished", "closed"} {
+		rooms[id] = &model.Room{
+			ID:      id,
+			Players: []*model.Player{players["1"], players["2"], players["3"]},
+			Secret:  3,
+		}
+		journal[id] = []model.RoomEvent{{RoomID: id, Type: global.RoomCreated, Players: []string{"1", "2", "3"}, Secret: 3}}
+	}
+	journal["finished"] = append(journal["finished"], model.RoomEvent{RoomID: "finished", Type: global.GameFinished, Secret: 3})
+	journal["closed"] = append(journal["closed"], model.RoomEvent{RoomID: "closed", Type: global.RoomClosed})
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{"4": players["4"], "5": players["5"]}),
+		repo.WithJournal(journal),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	metrics.WatchRepo(repo, func() int {
+		return len(serv.RunningRooms())
+	})
+	handler := handler.NewHandler(handler.WithService(serv))
+	router := mux.NewRouter()
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	router.Handle("/websocket", handler.Websocket())
+	return httptest.NewServer(router), serv
+}
+
+// guessLatencyCount returns how many guesses the guess latency histogram
+// observed so far.
+func guessLatencyCount(c C) string {
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if count, ok := strings.CutPrefix(line, "rooms_guess_latency_seconds_count "); ok {
+			return count
+		}
+	}
+	c.So(rec.Body.String(), ShouldContainSubstring, "rooms_guess_latency_seconds_count")
+	return ""
+}
+func prepareHealth(c C) (*httptest.Server, *handler.Handler, *service.Service) {
+	repo := repo.NewRepository(
+		repo.WithPlayers(map[string]*model.Player{}),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv))
+	router := mux.NewRouter()
+	router.Handle("/healthz", handler.Healthz()).Methods("GET")
+	router.Handle("/readyz", handler.Readyz()).Methods("GET")
+	return httptest.NewServer(router), handler, serv
+}
+func prepareShutdown(c C) (*websocket.Conn, *httptest.Server, *handler.Handler, *service.Service) {
+	p1 := &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Guess:    -1,
+	}
+	p2 := &model.Player{
+		ID:       "2",
+		NickName: "b",
+		Guess:    -1,
+	}
+	p3 := &model.Player{
+		ID:       "3",
+		NickName: "c",
+		Guess:    -1,
+	}
+	players := map[string]*model.Player{}
+	players["1"] = p1
+	players["2"] = p2
+	players["3"] = p3
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{p1, p2, p3},
+		Secret:  3,
+	}
+	// Nobody of room2 is connected.
+	abandoned := []*model.Player{}
+	for _, id := range []string{"4", "5", "6"} {
+		players[id] = &model.Player{
+			ID:       id,
+			NickName: "n" + id,
+			Guess:    -1,
+		}
+		abandoned = append(abandoned, players[id])
+	}
+	rooms["room2"] = &model.Room{
+		ID:      "room2",
+		Players: abandoned,
+		Secret:  5,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv))
+	s := httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s, handler, serv
+}
+func prepareHeartbeat(c C) (*websocket.Conn, *httptest.Server, repo.Repo) {
+	players := map[string]*model.Player{}
+	players["1"] = &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Guess:    -1,
+	}
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{players["1"]},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+		repo.WithJournal(map[string][]model.RoomEvent{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithHeartbeat(100*time.Millisecond, 300*time.Millisecond, time.Second),
+	)
+	s := httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s, repo
+}
+func prepareOrigins(c C) *httptest.Server {
+	repo := repo.NewRepository(
+		repo.WithPlayers(map[string]*model.Player{}),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithAllowedOrigins([]string{"https://game.example"}),
+	)
+	return httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+}
+func prepareRateLimit(c C) (*websocket.Conn, *httptest.Server) {
+	players := map[string]*model.Player{}
+	players["1"] = &model.Player{
+		ID:       "1",
+		NickName: "x",
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithRateLimits(
+			ratelimit.Rule{PerSecond: 0.1, Burst: 1},
+			ratelimit.Rule{PerSecond: 0.1, Burst: 2},
+			ratelimit.Rule{PerSecond: 0.1, Burst: 2},
+		),
+	)
+	router := mux.NewRouter()
+	router.Handle("/register", handler.Limit(handler.Register())).Methods("POST")
+	router.Handle("/websocket", handler.Websocket())
+	router.Handle("/limited/websocket", handler.Limit(handler.Websocket()))
+	s := httptest.NewServer(router)
+	u := "ws" + strings.TrimPrefix(s.URL, "http") + "/websocket"
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s
+}
+
+// waiting reports whether the player is on the waiting list.
+func waiting(r repo.ReadRepo, id string) bool {
+	for _, p := range r.GetWaitingListSnapshot() {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+func prepareCommandRouter(c C) (*websocket.Conn, *httptest.Server) {
+	players := map[string]*model.Player{}
+	players["1"] = &model.Player{
+		ID:       "1",
+		NickName: "a",
+	}
+	players["2"] = &model.Player{
+		ID:       "2",
+		NickName: "b",
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithCommand("echo", handler.Typed(func(ctx *handler.CommandContext, req *dto.JoinRequest) (string, error) {
+			return "echo:" + req.Id, nil
+		})),
+	)
+	s := httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s
+}
+func prepareDedup(c C) (*websocket.Conn, *httptest.Server, *service.Service, repo.Repo) {
+	p1 := &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Guess:    -1,
+	}
+	p2 := &model.Player{
+		ID:       "2",
+		NickName: "b",
+		Guess:    -1,
+	}
+	p3 := &model.Player{
+		ID:       "3",
+		NickName: "c",
+		Guess:    -1,
+	}
+	players := map[string]*model.Player{}
+	players["1"] = p1
+	players["2"] = p2
+	players["3"] = p3
+	players["4"] = &model.Player{
+		ID:       "4",
+		NickName: "d",
+		Guess:    -1,
+	}
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{p1, p2, p3},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv))
+	s := httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s, serv, repo
+}
+
+func prepareHello(c C) (*websocket.Conn, *httptest.Server) {
+	repo := repo.NewRepository(
+		repo.WithPlayers(map[string]*model.Player{}),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(
+		service.WithRepo(repo),
+		service.WithRatingSystem(service.Elo{K: 32}),
+	)
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithHeartbeat(25*time.Second, 60*time.Second, 10*time.Second),
+	)
+	s := httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s
+}
+
+func prepareCodec(c C, subprotocol string) (*websocket.Conn, *httptest.Server) {
+	players := map[string]*model.Player{}
+	players["1"] = &model.Player{
+		ID:       "1",
+		NickName: "a",
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv))
+	s := httptest.NewServer(http.HandlerFunc(handler.Websocket().ServeHTTP))
+	u := "ws" + strings.TrimPrefix(s.URL, "http")
+	dialer := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	ws, _, err := dialer.Dial(u, nil)
+	c.So(err, ShouldBeNil)
+	return ws, s
+}
+
+func preparePolling(c C) *httptest.Server {
+	p1 := &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Guess:    5,
+		Diff:     2,
+	}
+	p2 := &model.Player{
+		ID:       "2",
+		NickName: "b",
+		Guess:    4,
+		Diff:     1,
+	}
+	p3 := &model.Player{
+		ID:       "3",
+		NickName: "c",
+		Guess:    -1,
+	}
+	p4 := &model.Player{
+		ID:       "4",
+		NickName: "d",
+	}
+	players := map[string]*model.Player{}
+	players["1"] = p1
+	players["2"] = p2
+	players["3"] = p3
+	players["4"] = p4
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{p1, p2, p3},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv))
+	router := mux.NewRouter()
+	router.Handle("/players/{id}/join", handler.PollCommand("join")).Methods("POST")
+	router.Handle("/players/{id}/guess", handler.PollCommand("guess")).Methods("POST")
+	router.Handle("/players/{id}/leave", handler.PollCommand("leave")).Methods("POST")
+	router.Handle("/players/{id}/events", handler.PollEvents()).Methods("GET")
+	return httptest.NewServer(router)
+}
+
+func prepareSchema(c C) *httptest.Server {
+	p1 := &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Guess:    5,
+		Diff:     2,
+	}
+	p2 := &model.Player{
+		ID:       "2",
+		NickName: "b",
+		Guess:    4,
+		Diff:     1,
+	}
+	p3 := &model.Player{
+		ID:       "3",
+		NickName: "c",
+		Guess:    -1,
+	}
+	players := map[string]*model.Player{}
+	players["1"] = p1
+	players["2"] = p2
+	players["3"] = p3
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{p1, p2, p3},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv))
+	router := mux.NewRouter()
+	router.Handle("/openapi.json", handler.OpenAPI()).Methods("GET")
+	router.Handle("/asyncapi.json", handler.AsyncAPI()).Methods("GET")
+	router.Handle("/register", handler.Register()).Methods("POST")
+	router.Handle("/stats", handler.Stats()).Methods("GET")
+	router.Handle("/players/{id}/events", handler.PollEvents()).Methods("GET")
+	router.Handle("/websocket", handler.Websocket())
+	return httptest.NewServer(router)
+}
+
+// compileSchema compiles the schema at ref of the JSON document served at path.
+func compileSchema(c C, s *httptest.Server, path, ref string) *jsonschema.Schema {
+	resp, err := http.Get(s.URL + path)
+	c.So(err, ShouldBeNil)
+	defer resp.Body.Close()
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	c.So(compiler.AddResource(s.URL+path, resp.Body), ShouldBeNil)
+	sch, err := compiler.Compile(s.URL + path + "#" + ref)
+	c.So(err, ShouldBeNil)
+	return sch
+}
+
+func validateFrame(sch *jsonschema.Schema, b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	return sch.Validate(v)
+}
+
+// connDropper records the hijacked websocket connections of a handler so that
+// tests can drop them.
+type connDropper struct {
+	conns []net.Conn
+	mutex sync.Mutex
+}
+
+func (d *connDropper) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&hijackRecorder{ResponseWriter: w, dropper: d}, r)
+	})
+}
+
+func (d *connDropper) drop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = nil
+}
+
+type hijackRecorder struct {
+	http.ResponseWriter
+	dropper *connDropper
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.dropper.mutex.Lock()
+		h.dropper.conns = append(h.dropper.conns, conn)
+		h.dropper.mutex.Unlock()
+	}
+	return conn, rw, err
+}
+
+func prepareClient(c C) (*client.Client, *httptest.Server, *connDropper, repo.Repo) {
+	p1 := &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Guess:    5,
+		Diff:     2,
+	}
+	p2 := &model.Player{
+		ID:       "2",
+		NickName: "b",
+		Guess:    4,
+		Diff:     1,
+	}
+	p3 := &model.Player{
+		ID:       "3",
+		NickName: "c",
+		Guess:    -1,
+	}
+	players := map[string]*model.Player{}
+	players["1"] = p1
+	players["2"] = p2
+	players["3"] = p3
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{p1, p2, p3},
+		Secret:  3,
+	}
+	rooms["room0"] = &model.Room{
+		ID:        "room0",
+		Players:   []*model.Player{p1, p2, p3},
+		Secret:    7,
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	journal := map[string][]model.RoomEvent{}
+	journal["room0"] = []model.RoomEvent{
+		{RoomID: "room0", Type: global.RoomCreated, Time: time.Now().Add(-time.Hour), Players: []string{"1", "2", "3"}, Secret: 7},
+		{RoomID: "room0", Type: global.GameFinished, Time: time.Now().Add(-time.Hour), Secret: 7},
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+		repo.WithJournal(journal),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithMatchmakingInterval(50*time.Millisecond),
+	)
+	router := mux.NewRouter()
+	router.Handle("/register", handler.Register()).Methods("POST")
+	dropper := &connDropper{}
+	router.Handle("/websocket", dropper.wrap(handler.Websocket()))
+	s := httptest.NewServer(router)
+	cl := client.New(s.URL, client.WithReconnect(50*time.Millisecond, time.Second))
+	c.So(cl.Connect(context.Background()), ShouldBeNil)
+	return cl, s, dropper, repo
+}
+func prepareBots(c C, after time.Duration) (*httptest.Server, *service.Service) {
+	p1 := &model.Player{
+		ID:       "1",
+		NickName: "a",
+		Trophies: 10,
+	}
+	players := map[string]*model.Player{}
+	players["1"] = p1
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(map[string]*model.Room{}),
+		repo.WithWaitingList(map[string]*model.Player{}),
+		repo.WithJournal(map[string][]model.RoomEvent{}),
+	)
+	serv := service.NewService(
+		service.WithRepo(repo),
+		service.WithBots(after, service.MidpointBot{}, &service.AdaptiveBot{}),
+	)
+	_, err := serv.CurrentSeason()
+	c.So(err, ShouldBeNil)
+	c.So(serv.Join("1"), ShouldBeNil)
+	handler := handler.NewHandler(handler.WithService(serv))
+	router := mux.NewRouter()
+	router.Handle("/stats", handler.Stats()).Methods("GET")
+	router.Handle("/rooms/{id}/events", handler.RoomEvents()).Methods("GET")
+	router.Handle("/seasons/{id}/leaderboard", handler.SeasonLeaderboard()).Methods("GET")
+	return httptest.NewServer(router), serv
+}
+func prepareAdmin(c C) (*client.Client, *httptest.Server, *service.Service, repo.Repo) {
+	players := map[string]*model.Player{}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		players[id] = &model.Player{
+			ID:       id,
+			NickName: "n" + id,
+			Guess:    -1,
+		}
+	}
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{players["1"], players["2"], players["3"]},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+		repo.WithJournal(map[string][]model.RoomEvent{}),
+	)
+	serv := service.NewService(service.WithRepo(repo))
+	handler := handler.NewHandler(handler.WithService(serv), handler.WithAdminToken("secret"))
+	router := mux.NewRouter()
+	router.Handle("/register", handler.Register()).Methods("POST")
+	router.Handle("/rooms/{id}/events", handler.RoomEvents()).Methods("GET")
+	router.Handle("/websocket", handler.Websocket())
+	router.Handle("/admin/sessions", handler.Admin(handler.Sessions())).Methods("GET")
+	router.Handle("/admin/sessions/{id}/kick", handler.Admin(handler.Kick())).Methods("POST")
+	router.Handle("/admin/bans", handler.Admin(handler.Bans())).Methods("GET")
+	router.Handle("/admin/bans/{kind}/{value}", handler.Admin(handler.Ban())).Methods("PUT")
+	router.Handle("/admin/bans/{kind}/{value}", handler.Admin(handler.Unban())).Methods("DELETE")
+	router.Handle("/admin/rooms/{id}/close", handler.Admin(handler.CloseRoom())).Methods("POST")
+	router.Handle("/admin/waiting-list", handler.Admin(handler.ClearWaitingList())).Methods("DELETE")
+	router.Handle("/admin/announcements", handler.Admin(handler.Announce())).Methods("POST")
+	s := httptest.NewServer(router)
+	return client.New(s.URL, client.WithAdminToken("secret")), s, serv, repo
+}
+func connectClient(c C, s *httptest.Server) *client.Client {
+	cl := client.New(s.URL, client.WithReconnect(50*time.Millisecond, time.Second))
+	c.So(cl.Connect(context.Background()), ShouldBeNil)
+	return cl
+}
+
+// prepareChat serves the websocket on /websocket and, with connections that
+// the returned dropper drops, on /dropped/websocket.
+func prepareChat(c C) (*httptest.Server, *connDropper) {
+	players := map[string]*model.Player{}
+	for _, id := range []string{"1", "2", "3", "4"} {
+		players[id] = &model.Player{
+			ID:       id,
+			NickName: "n" + id,
+			Guess:    -1,
+		}
+	}
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{players["1"], players["2"], players["3"]},
+		Secret:  3,
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(players),
+		repo.WithRooms(rooms),
+		repo.WithWaitingList(map[string]*model.Player{}),
+		repo.WithJournal(map[string][]model.RoomEvent{}),
+	)
+	serv := service.NewService(
+		service.WithRepo(repo),
+		service.WithChat(10, 3, []string{"darn", "şapşal", "aptal"}),
+	)
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithChatRateLimit(ratelimit.Rule{PerSecond: 0.1, Burst: 3}),
+	)
+	router := mux.NewRouter()
+	dropper := &connDropper{}
+	router.Handle("/websocket", handler.Websocket())
+	router.Handle("/dropped/websocket", dropper.wrap(handler.Websocket()))
+	return httptest.NewServer(router), dropper
+}
+func prepareChatBacklog(c C, backlog int) *service.Service {
+	p := &model.Player{
+		ID:       "1",
+		NickName: "n1",
+		Guess:    -1,
+	}
+	rooms := map[string]*model.Room{}
+	rooms["room1"] = &model.Room{
+		ID:      "room1",
+		Players: []*model.Player{p},
+	}
+	repo := repo.NewRepository(
+		repo.WithPlayers(map[string]*model.Player{"1": p}),
+		repo.WithRooms(rooms),
+		repo.WithJournal(map[string][]model.RoomEvent{}),
+	)
+	return service.NewService(
+		service.WithRepo(repo),
+		service.WithChat(10, backlog, nil),
+	)
+}
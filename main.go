@@ -2,50 +2,124 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"rooms/config"
+	"rooms/global"
 	"rooms/handler"
+	"rooms/metrics"
 	"rooms/model"
+	"rooms/ratelimit"
 	"rooms/repo"
 	"rooms/service"
 )
 
 func main() {
+	cfg := config.Load()
+	logger := cfg.Logger(os.Stdout)
+	slog.SetDefault(logger)
 	repo := repo.NewRepository(
 		repo.WithPlayers(map[string]*model.Player{}),
 		repo.WithRooms(map[string]*model.Room{}),
 		repo.WithWaitingList(map[string]*model.Player{}),
+		repo.WithJournal(map[string][]model.RoomEvent{}),
 	)
-	serv := service.NewService(service.WithRepo(repo))
-	handler := handler.NewHandler(handler.WithService(serv))
+	bots := make([]service.BotStrategy, 0, len(cfg.Bots))
+	for _, name := range cfg.Bots {
+		bots = append(bots, service.NewBotStrategy(name))
+	}
+	serv := service.NewService(
+		service.WithRepo(repo),
+		service.WithLogger(logger),
+		service.WithRatingSystem(service.NewRatingSystem(cfg.RatingSystem, cfg.EloK)),
+		service.WithBots(cfg.BotWait, bots...),
+		service.WithChat(cfg.ChatMaxLength, cfg.ChatBacklog, cfg.ChatFilter),
+	)
+	handler := handler.NewHandler(
+		handler.WithService(serv),
+		handler.WithLogger(logger),
+		handler.WithHeartbeat(cfg.PingInterval, cfg.PongWait, cfg.WriteWait),
+		handler.WithAllowedOrigins(cfg.AllowedOrigins),
+		handler.WithBufferSizes(cfg.ReadBufferSize, cfg.WriteBufferSize),
+		handler.WithHandshakeTimeout(cfg.HandshakeTimeout),
+		handler.WithSubprotocols(cfg.Subprotocols),
+		handler.WithCompression(cfg.Compression),
+		handler.WithReadLimit(cfg.MaxMessageSize),
+		handler.WithDedupWindow(cfg.DedupWindow),
+		handler.WithAdminToken(cfg.AdminToken),
+		handler.WithChatRateLimit(ratelimit.Rule{PerSecond: cfg.ChatRate, Burst: cfg.ChatBurst}),
+		handler.WithRateLimits(
+			ratelimit.Rule{PerSecond: cfg.HTTPRate, Burst: cfg.HTTPBurst},
+			ratelimit.Rule{PerSecond: cfg.WSConnRate, Burst: cfg.WSConnBurst},
+			ratelimit.Rule{PerSecond: cfg.WSPlayerRate, Burst: cfg.WSPlayerBurst},
+		),
+	)
+	metrics.WatchRepo(repo, func() int {
+		return len(serv.RunningRooms())
+	})
 
 	mux := mux.NewRouter()
-	mux.Handle("/register", handler.Register()).Methods("POST")
-	mux.Handle("/stats", handler.Stats()).Methods("GET")
+	mux.Handle("/register", handler.Limit(handler.Register())).Methods("POST")
+	mux.Handle("/stats", handler.Limit(handler.Stats())).Methods("GET")
+	mux.Handle("/rooms/{id}/events", handler.Limit(handler.RoomEvents())).Methods("GET")
+	mux.Handle("/seasons", handler.Limit(handler.Seasons())).Methods("GET")
+	mux.Handle("/seasons/{id}/leaderboard", handler.Limit(handler.SeasonLeaderboard())).Methods("GET")
+	mux.Handle("/players/{id}/join", handler.Limit(handler.PollCommand("join"))).Methods("POST")
+	mux.Handle("/players/{id}/guess", handler.Limit(handler.PollCommand("guess"))).Methods("POST")
+	mux.Handle("/players/{id}/leave", handler.Limit(handler.PollCommand("leave"))).Methods("POST")
+	mux.Handle("/players/{id}/chat", handler.Limit(handler.PollCommand("chat"))).Methods("POST")
+	mux.Handle("/players/{id}/events", handler.Limit(handler.PollEvents())).Methods("GET")
+	mux.Handle("/openapi.json", handler.OpenAPI()).Methods("GET")
+	mux.Handle("/asyncapi.json", handler.AsyncAPI()).Methods("GET")
+	mux.Handle("/metrics", metrics.Handler()).Methods("GET")
+	mux.Handle("/admin/sessions", handler.Admin(handler.Sessions())).Methods("GET")
+	mux.Handle("/admin/sessions/{id}/kick", handler.Admin(handler.Kick())).Methods("POST")
+	mux.Handle("/admin/bans", handler.Admin(handler.Bans())).Methods("GET")
+	mux.Handle("/admin/bans/{kind}/{value}", handler.Admin(handler.Ban())).Methods("PUT")
+	mux.Handle("/admin/bans/{kind}/{value}", handler.Admin(handler.Unban())).Methods("DELETE")
+	mux.Handle("/admin/rooms/{id}/close", handler.Admin(handler.CloseRoom())).Methods("POST")
+	mux.Handle("/admin/waiting-list", handler.Admin(handler.ClearWaitingList())).Methods("DELETE")
+	mux.Handle("/admin/announcements", handler.Admin(handler.Announce())).Methods("POST")
+	mux.Handle("/healthz", handler.Healthz()).Methods("GET")
+	mux.Handle("/readyz", handler.Readyz()).Methods("GET")
 
-	mux.Handle("/websocket", handler.Websocket())
+	mux.Handle("/websocket", handler.Limit(handler.Websocket()))
 
 	srv := &http.Server{
-		Addr:    ":8080",
+		Addr:    cfg.Addr,
 		Handler: mux,
 	}
 	go func() {
-		log.Fatal(srv.ListenAndServe())
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("server stopped unexpectedly", "err", err)
+			os.Exit(1)
+		}
 	}()
-	log.Println("server started successfully")
 
-	stopC := make(chan os.Signal)
-	signal.Notify(stopC, os.Interrupt)
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go serv.RunSeasons(jobsCtx, cfg.SeasonLength)
+	go serv.RunMatchmaker(jobsCtx, global.CreateRoomTime*time.Second)
+	logger.Info("server started successfully", "addr", cfg.Addr)
+
+	stopC := make(chan os.Signal, 1)
+	signal.Notify(stopC, os.Interrupt, syscall.SIGTERM)
 	<-stopC
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	log.Println("server stopping ...")
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace+10*time.Second)
+	logger.Info("server stopping ...")
 	defer cancel()
+	handler.Shutdown(ctx, cfg.ShutdownGrace)
+	stopJobs()
 
-	log.Fatal(srv.Shutdown(ctx))
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("server shutdown failed", "err", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"rooms/repo"
+)
+
+const namespace = "rooms"
+
+var (
+	Registrations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Number of registered players.",
+	})
+	ConnectedWebsockets = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Number of currently connected websockets.",
+	})
+	GamesFinished = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "games_finished_total",
+		Help:      "Number of finished games.",
+	})
+	GuessLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "guess_latency_seconds",
+		Help:      "Time between the creation of a room and a guess of one of its players.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 15, 20, 30, 60},
+	})
+	MatchmakingWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "matchmaking_wait_seconds",
+		Help:      "Time a player spent in the waiting list before joining a room.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	})
+	RejectedOrigins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_rejected_origins_total",
+		Help:      "Number of websocket upgrades rejected because of their origin.",
+	})
+	CommandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_errors_total",
+		Help:      "Number of websocket command errors by command and error code.",
+	}, []string{"cmd", "code"})
+)
+
+// WatchRepo exposes the size of the waiting list of the given repository and
+// the number of running rooms, as counted by activeRooms, as gauges.
+func WatchRepo(r repo.ReadRepo, activeRooms func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "waiting_players",
+		Help:      "Number of players in the waiting list.",
+	}, func() float64 {
+		return float64(len(r.GetWaitingListSnapshot()))
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_rooms",
+		Help:      "Number of rooms whose game has neither finished nor been closed.",
+	}, func() float64 {
+		return float64(activeRooms())
+	})
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
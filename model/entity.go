@@ -1,18 +1,24 @@
 package model
 
+import "time"
+
 type Player struct {
 	ID       string
 	NickName string
 	Score    int
+	Trophies int
 	Guess    int
 	Diff     int
 	Rank     int
+	JoinedAt time.Time
+	Bot      bool
 }
 
 type Room struct {
-	ID      string
-	Players []*Player
-	Secret  int
+	ID        string
+	Players   []*Player
+	Secret    int
+	CreatedAt time.Time
 }
 
 type Stats struct {
@@ -30,3 +36,48 @@ type Ranking struct {
 	Rank        int
 	DeltaTrophy int
 }
+
+type RoomEvent struct {
+	Seq      int
+	RoomID   string
+	Type     string
+	Time     time.Time
+	PlayerID string
+	Players  []string
+	Bots     []string
+	Trophies []int
+	Guess    int
+	Secret   int
+	Rankings []Ranking
+	Reason   string
+}
+
+type Season struct {
+	ID          int
+	Start       time.Time
+	End         time.Time
+	Leaderboard []LeaderboardEntry
+}
+
+type LeaderboardEntry struct {
+	Rank     int
+	PlayerID string
+	NickName string
+	Trophies int
+}
+
+// Ban keeps a player id or a nickname from playing.
+type Ban struct {
+	Kind      string
+	Value     string
+	Reason    string
+	CreatedAt time.Time
+}
+
+type ChatMessage struct {
+	RoomID   string
+	PlayerID string
+	NickName string
+	Message  string
+	Time     time.Time
+}
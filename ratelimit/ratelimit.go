@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// Rule describes a token bucket refilled with PerSecond tokens up to Burst.
+// A rule with a zero PerSecond does not limit anything.
+type Rule struct {
+	PerSecond float64
+	Burst     int
+}
+
+// Limiter keeps one token bucket per key, e.g. per IP, connection or player.
+type Limiter struct {
+	rule      Rule
+	ttl       time.Duration
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func New(rule Rule) *Limiter {
+	return &Limiter{
+		rule:      rule,
+		ttl:       10 * time.Minute,
+		buckets:   map[string]*bucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty it
+// returns false and how long the caller should wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.rule.PerSecond <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(l.rule.PerSecond), l.rule.Burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Second
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+func (l *Limiter) Forget(key string) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	delete(l.buckets, key)
+	l.mutex.Unlock()
+}
+
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > l.ttl {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
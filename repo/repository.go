@@ -6,6 +6,7 @@ import (
 	"rooms/global"
 	"rooms/model"
 	"sync"
+	"time"
 )
 
 type WriteRepo interface {
@@ -14,15 +15,31 @@ type WriteRepo interface {
 	Join(p *model.Player) error
 	CreateRoom(r *model.Room) error
 	RemoveFromWaitingList(id string) error
+	AppendEvent(e model.RoomEvent) error
+	StartSeason(start time.Time) (model.Season, error)
+	CloseSeason(id int, end time.Time, leaderboard []model.LeaderboardEntry) error
+	Ban(b model.Ban) error
+	Unban(kind, value string) error
+	AppendChat(m model.ChatMessage, backlog int) error
 }
 
 type ReadRepo interface {
 	GetAllPlayers() map[string]*model.Player
+	GetPlayersSnapshot() []*model.Player
 	GetPlayerById(id string) (*model.Player, error)
 	GetWaitingList() map[string]*model.Player
+	GetWaitingListSnapshot() []*model.Player
 	GetPlayerByNickName(nickName string) (*model.Player, error)
 	GetAllRooms() map[string]*model.Room
 	GetRoomById(id string) (*model.Room, error)
+	GetRoomEvents(roomId string) []model.RoomEvent
+	GetSeasons() []model.Season
+	GetSeasonById(id int) (model.Season, error)
+	GetRoomsSnapshot() []*model.Room
+	GetBans() []model.Ban
+	GetBan(kind, value string) (model.Ban, bool)
+	GetChat(roomId string) []model.ChatMessage
+	Ping() error
 }
 
 type Repo interface {
@@ -36,6 +53,10 @@ type repo struct {
 	rooms       map[string]*model.Room
 	players     map[string]*model.Player
 	waitingList map[string]*model.Player
+	journal     map[string][]model.RoomEvent
+	seasons     []model.Season
+	bans        map[string]model.Ban
+	chat        map[string][]model.ChatMessage
 	mutex       sync.RWMutex
 }
 
@@ -64,6 +85,19 @@ func WithWaitingList(waitingList map[string]*model.Player) func(*repo) {
 		s.waitingList = waitingList
 	}
 }
+
+func WithJournal(journal map[string][]model.RoomEvent) func(*repo) {
+	return func(s *repo) {
+		s.journal = journal
+	}
+}
+
+func WithSeasons(seasons []model.Season) func(*repo) {
+	return func(s *repo) {
+		s.seasons = seasons
+	}
+}
+
 func (a *repo) Register(nickName string) (string, error) {
 	uuid := uuid.New().String()
 	a.mutex.Lock()
@@ -91,6 +125,18 @@ func (a *repo) GetAllPlayers() map[string]*model.Player {
 	return p
 }
 
+// GetPlayersSnapshot returns the players at the time of the call, safe to
+// range over while players register.
+func (a *repo) GetPlayersSnapshot() []*model.Player {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	res := make([]*model.Player, 0, len(a.players))
+	for _, p := range a.players {
+		res = append(res, p)
+	}
+	return res
+}
+
 func (a *repo) GetPlayerById(id string) (*model.Player, error) {
 	a.mutex.RLock()
 	p, ok := a.players[id]
@@ -99,7 +145,7 @@ func (a *repo) GetPlayerById(id string) (*model.Player, error) {
 		return p, nil
 	}
 
-	return nil, global.NewError(http.StatusNotFound, global.NotFoundErr, "player not found")
+	return nil, global.ErrNotFound.WithMessage("player not found")
 }
 
 func (a *repo) GetRoomById(id string) (*model.Room, error) {
@@ -110,7 +156,7 @@ func (a *repo) GetRoomById(id string) (*model.Room, error) {
 		return p, nil
 	}
 
-	return nil, global.NewError(http.StatusNotFound, global.NotFoundErr, "room not found")
+	return nil, global.ErrNotFound.WithMessage("room not found")
 }
 
 func (a *repo) GetPlayerByNickName(nickName string) (*model.Player, error) {
@@ -127,7 +173,7 @@ func (a *repo) GetPlayerByNickName(nickName string) (*model.Player, error) {
 		return p, nil
 	}
 
-	return nil, global.NewError(http.StatusNotFound, global.NotFoundErr, "player not found")
+	return nil, global.ErrNotFound.WithMessage("player not found")
 }
 
 func (a *repo) GetAllRooms() map[string]*model.Room {
@@ -137,6 +183,18 @@ func (a *repo) GetAllRooms() map[string]*model.Room {
 	return p
 }
 
+// GetRoomsSnapshot returns the rooms at the time of the call, safe to range
+// over while rooms are created.
+func (a *repo) GetRoomsSnapshot() []*model.Room {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	res := make([]*model.Room, 0, len(a.rooms))
+	for _, r := range a.rooms {
+		res = append(res, r)
+	}
+	return res
+}
+
 func (a *repo) Join(p *model.Player) error {
 	a.mutex.Lock()
 	a.waitingList[p.ID] = p
@@ -163,3 +221,158 @@ func (a *repo) GetWaitingList() map[string]*model.Player {
 	a.mutex.RUnlock()
 	return p
 }
+
+// GetWaitingListSnapshot returns the waiting players at the time of the call,
+// safe to range over while players join and leave.
+func (a *repo) GetWaitingListSnapshot() []*model.Player {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	res := make([]*model.Player, 0, len(a.waitingList))
+	for _, p := range a.waitingList {
+		res = append(res, p)
+	}
+	return res
+}
+
+func (a *repo) AppendEvent(e model.RoomEvent) error {
+	e.Players = append([]string(nil), e.Players...)
+	e.Trophies = append([]int(nil), e.Trophies...)
+	e.Rankings = append([]model.Ranking(nil), e.Rankings...)
+	a.mutex.Lock()
+	if a.journal == nil {
+		a.journal = map[string][]model.RoomEvent{}
+	}
+	e.Seq = len(a.journal[e.RoomID]) + 1
+	a.journal[e.RoomID] = append(a.journal[e.RoomID], e)
+	a.mutex.Unlock()
+	return nil
+}
+
+func (a *repo) GetRoomEvents(roomId string) []model.RoomEvent {
+	a.mutex.RLock()
+	events := make([]model.RoomEvent, len(a.journal[roomId]))
+	copy(events, a.journal[roomId])
+	a.mutex.RUnlock()
+	for i := range events {
+		events[i].Players = append([]string(nil), events[i].Players...)
+		events[i].Trophies = append([]int(nil), events[i].Trophies...)
+		events[i].Rankings = append([]model.Ranking(nil), events[i].Rankings...)
+	}
+	return events
+}
+
+func (a *repo) StartSeason(start time.Time) (model.Season, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if n := len(a.seasons); n > 0 && a.seasons[n-1].End.IsZero() {
+		return model.Season{}, global.NewError(http.StatusConflict, global.InvalidRequest, "season already running")
+	}
+	s := model.Season{
+		ID:    len(a.seasons) + 1,
+		Start: start,
+	}
+	a.seasons = append(a.seasons, s)
+	return s, nil
+}
+
+func (a *repo) CloseSeason(id int, end time.Time, leaderboard []model.LeaderboardEntry) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for i := range a.seasons {
+		if a.seasons[i].ID == id {
+			if !a.seasons[i].End.IsZero() {
+				return global.NewError(http.StatusConflict, global.InvalidRequest, "season already closed")
+			}
+			a.seasons[i].End = end
+			a.seasons[i].Leaderboard = append([]model.LeaderboardEntry(nil), leaderboard...)
+			return nil
+		}
+	}
+	return global.ErrNotFound.WithMessage("season not found")
+}
+
+func (a *repo) GetSeasons() []model.Season {
+	a.mutex.RLock()
+	seasons := make([]model.Season, len(a.seasons))
+	copy(seasons, a.seasons)
+	a.mutex.RUnlock()
+	return seasons
+}
+
+func (a *repo) GetSeasonById(id int) (model.Season, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	for _, s := range a.seasons {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return model.Season{}, global.ErrNotFound.WithMessage("season not found")
+}
+
+func (a *repo) Ban(b model.Ban) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.bans == nil {
+		a.bans = map[string]model.Ban{}
+	}
+	a.bans[b.Kind+":"+b.Value] = b
+	return nil
+}
+
+func (a *repo) Unban(kind, value string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, ok := a.bans[kind+":"+value]; !ok {
+		return global.ErrNotFound.WithMessage("ban not found")
+	}
+	delete(a.bans, kind+":"+value)
+	return nil
+}
+
+func (a *repo) GetBans() []model.Ban {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	bans := make([]model.Ban, 0, len(a.bans))
+	for _, b := range a.bans {
+		bans = append(bans, b)
+	}
+	return bans
+}
+
+func (a *repo) GetBan(kind, value string) (model.Ban, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	b, ok := a.bans[kind+":"+value]
+	return b, ok
+}
+
+// AppendChat keeps the last backlog messages of a room.
+func (a *repo) AppendChat(m model.ChatMessage, backlog int) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.chat == nil {
+		a.chat = map[string][]model.ChatMessage{}
+	}
+	messages := append(a.chat[m.RoomID], m)
+	if len(messages) > backlog {
+		messages = append([]model.ChatMessage(nil), messages[len(messages)-backlog:]...)
+	}
+	a.chat[m.RoomID] = messages
+	return nil
+}
+
+func (a *repo) GetChat(roomId string) []model.ChatMessage {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return append([]model.ChatMessage(nil), a.chat[roomId]...)
+}
+
+func (a *repo) Ping() error {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.players == nil || a.rooms == nil || a.waitingList == nil {
+		return global.ErrNotReady.WithMessage("repository is not initialized")
+	}
+	return nil
+}
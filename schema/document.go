@@ -0,0 +1,132 @@
+package schema
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Endpoint describes an HTTP endpoint of the OpenAPI document.
+type Endpoint struct {
+	Method   string
+	Path     string
+	Summary  string
+	Query    []string
+	Request  Schema
+	Status   int
+	Response Schema
+	// ContentType of the response, application/json when empty.
+	ContentType string
+	// Auth marks endpoints requiring the bearer token.
+	Auth bool
+}
+
+// Message describes a websocket frame of the AsyncAPI document.
+type Message struct {
+	Name    string
+	Summary string
+	Payload Schema
+}
+
+// OpenAPI builds an OpenAPI 3.1 document of the endpoints. Error responses use
+// the given error schema.
+func OpenAPI(title, version string, c *Components, errorSchema Schema, endpoints []Endpoint) Schema {
+	paths := Schema{}
+	components := Schema{"schemas": c.Schemas()}
+	for _, e := range endpoints {
+		contentType := e.ContentType
+		if len(contentType) == 0 {
+			contentType = "application/json"
+		}
+		op := Schema{
+			"summary": e.Summary,
+			"responses": Schema{
+				strconv.Itoa(e.Status): Schema{
+					"description": http.StatusText(e.Status),
+					"content":     Schema{contentType: Schema{"schema": e.Response}},
+				},
+				"default": Schema{
+					"description": "Error",
+					"content":     Schema{"application/json": Schema{"schema": errorSchema}},
+				},
+			},
+		}
+		params := make([]Schema, 0)
+		for _, segment := range strings.Split(e.Path, "/") {
+			if name, ok := strings.CutPrefix(segment, "{"); ok {
+				params = append(params, Schema{
+					"name":     strings.TrimSuffix(name, "}"),
+					"in":       "path",
+					"required": true,
+					"schema":   Schema{"type": "string"},
+				})
+			}
+		}
+		for _, q := range e.Query {
+			params = append(params, Schema{
+				"name":   q,
+				"in":     "query",
+				"schema": Schema{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if e.Auth {
+			op["security"] = []Schema{{"bearerAuth": []string{}}}
+			components["securitySchemes"] = Schema{
+				"bearerAuth": Schema{"type": "http", "scheme": "bearer"},
+			}
+		}
+		if e.Request != nil {
+			op["requestBody"] = Schema{
+				"required": true,
+				"content":  Schema{"application/json": Schema{"schema": e.Request}},
+			}
+		}
+		item, ok := paths[e.Path].(Schema)
+		if !ok {
+			item = Schema{}
+			paths[e.Path] = item
+		}
+		item[strings.ToLower(e.Method)] = op
+	}
+	return Schema{
+		"openapi":    "3.1.0",
+		"info":       Schema{"title": title, "version": version},
+		"paths":      paths,
+		"components": components,
+	}
+}
+
+// AsyncAPI builds an AsyncAPI 2.6 document of a websocket channel. publish are
+// the frames sent by clients, subscribe the frames sent by the server.
+func AsyncAPI(title, version, channel string, c *Components, publish, subscribe []Message) Schema {
+	messages := Schema{}
+	refs := func(list []Message) Schema {
+		oneOf := make([]Schema, 0, len(list))
+		for _, m := range list {
+			messages[m.Name] = Schema{
+				"name":    m.Name,
+				"summary": m.Summary,
+				"payload": m.Payload,
+			}
+			oneOf = append(oneOf, Schema{"$ref": "#/components/messages/" + m.Name})
+		}
+		return Schema{"message": Schema{"oneOf": oneOf}}
+	}
+	return Schema{
+		"asyncapi": "2.6.0",
+		"info":     Schema{"title": title, "version": version},
+		"channels": Schema{
+			channel: Schema{
+				"publish":   refs(publish),
+				"subscribe": refs(subscribe),
+			},
+		},
+		"components": Schema{
+			"schemas":  c.Schemas(),
+			"messages": messages,
+		},
+	}
+}
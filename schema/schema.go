@@ -0,0 +1,160 @@
+package schema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema (draft 2020-12) document or sub-schema.
+type Schema = map[string]any
+
+const refPrefix = "#/components/schemas/"
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// Components collects the schemas of named struct types so that documents can
+// refer to them under #/components/schemas.
+type Components struct {
+	schemas map[string]Schema
+}
+
+func NewComponents() *Components {
+	return &Components{schemas: map[string]Schema{}}
+}
+
+func (c *Components) Schemas() map[string]Schema {
+	return c.schemas
+}
+
+// Ref returns a reference to the schema of v's type, generating it and every
+// type it uses on first use. omit drops top level fields by json name.
+func (c *Components) Ref(v any, omit ...string) Schema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if len(omit) > 0 {
+		return c.object(t, omit)
+	}
+	return c.of(t)
+}
+
+func (c *Components) of(t reflect.Type) Schema {
+	switch {
+	case t == timeType:
+		return Schema{"type": "string", "format": "date-time"}
+	case t == rawType:
+		return Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return c.of(t.Elem())
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": c.of(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": c.of(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return c.object(t, nil)
+		}
+		if _, ok := c.schemas[t.Name()]; !ok {
+			c.schemas[t.Name()] = Schema{}
+			c.schemas[t.Name()] = c.object(t, nil)
+		}
+		return Schema{"$ref": refPrefix + t.Name()}
+	default:
+		return Schema{}
+	}
+}
+
+// object describes a struct the way encoding/json and the command validator
+// treat it: unknown fields are not allowed, fields tagged validate:"required"
+// or serialized without omitempty are required, nil slices, maps and pointers
+// without omitempty may be null, and min and max rules bound integers.
+func (c *Components) object(t reflect.Type, omit []string) Schema {
+	properties := Schema{}
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		name := tag[0]
+		if name == "-" || contains(omit, name) {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		omitempty := contains(tag[1:], "omitempty")
+		rules := strings.Split(f.Tag.Get("validate"), ",")
+		p := c.of(f.Type)
+		for _, rule := range rules {
+			if max, ok := strings.CutPrefix(rule, "max="); ok && f.Type.Kind() == reflect.String {
+				p = Schema{"type": "string", "maxLength": atoi(max)}
+			}
+			if p["type"] == "integer" {
+				if min, ok := strings.CutPrefix(rule, "min="); ok {
+					p["minimum"] = atoi(min)
+				}
+				if max, ok := strings.CutPrefix(rule, "max="); ok {
+					p["maximum"] = atoi(max)
+				}
+			}
+		}
+		if !omitempty && nullable(f.Type) && !contains(rules, "required") {
+			p = Schema{"anyOf": []Schema{p, {"type": "null"}}}
+		}
+		properties[name] = p
+		if contains(rules, "required") || (!omitempty && len(f.Tag.Get("validate")) == 0) {
+			required = append(required, name)
+		}
+	}
+	res := Schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		res["required"] = required
+	}
+	return res
+}
+
+func nullable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+		return true
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"rooms/dto"
+	"rooms/global"
+	"rooms/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+// botPrefix starts the nicknames of bots. Players cannot register nicknames
+// starting with it, so nobody can take over a bot through Register.
+const botPrefix = "bot-"
+
+var errBotPlayer = global.ErrUnauthorized.WithMessage("bots are played by the server")
+
+// BotStrategy picks the guesses of bot players. A strategy is shared by all
+// bots using it and must be safe for concurrent use.
+type BotStrategy interface {
+	Name() string
+	Guess(min, max int) int
+	// Observe is called with the secret of every game the bot played.
+	Observe(secret int)
+}
+
+// NewBotStrategy returns the strategy with the given name, a random one for
+// unknown names.
+func NewBotStrategy(name string) BotStrategy {
+	switch name {
+	case "midpoint":
+		return MidpointBot{}
+	case "adaptive":
+		return &AdaptiveBot{}
+	default:
+		return RandomBot{}
+	}
+}
+
+// RandomBot guesses uniformly at random.
+type RandomBot struct{}
+
+func (RandomBot) Name() string {
+	return "random"
+}
+
+func (RandomBot) Guess(min, max int) int {
+	return rand.Intn(max-min+1) + min
+}
+
+func (RandomBot) Observe(int) {}
+
+// MidpointBot always guesses the middle of the range, which minimizes the
+// worst case difference.
+type MidpointBot struct{}
+
+func (MidpointBot) Name() string {
+	return "midpoint"
+}
+
+func (MidpointBot) Guess(min, max int) int {
+	return (min + max) / 2
+}
+
+func (MidpointBot) Observe(int) {}
+
+// AdaptiveBot guesses the mean of the secrets it has seen so far, the midpoint
+// before its first game.
+type AdaptiveBot struct {
+	mutex sync.Mutex
+	sum   int
+	count int
+}
+
+func (*AdaptiveBot) Name() string {
+	return "adaptive"
+}
+
+func (a *AdaptiveBot) Guess(min, max int) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.count == 0 {
+		return (min + max) / 2
+	}
+	return (a.sum + a.count/2) / a.count
+}
+
+func (a *AdaptiveBot) Observe(secret int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.sum += secret
+	a.count++
+}
+
+type bot struct {
+	player   *model.Player
+	strategy BotStrategy
+	busy     bool
+}
+
+// WithBots lets the matchmaker fill rooms with bots once the oldest waiting
+// player has waited for after. Bots use the strategies in turn. Bots are
+// disabled when after is zero or no strategy is given.
+func WithBots(after time.Duration, strategies ...BotStrategy) func(*Service) {
+	return func(s *Service) {
+		s.botWait = after
+		s.botStrategies = strategies
+	}
+}
+
+// checkNickName rejects the nicknames kept for bots.
+func checkNickName(nickName string) error {
+	if strings.HasPrefix(strings.ToLower(nickName), botPrefix) {
+		return global.ErrInvalidParams.WithDetails([]dto.FieldError{{Field: "nickname", Rule: "reserved"}})
+	}
+	return nil
+}
+
+func (a *Service) botsEnabled() bool {
+	return a.botWait > 0 && len(a.botStrategies) > 0
+}
+
+// idleBots returns n bots that are not playing, registering new ones when the
+// pool runs short. The caller holds matchMutex.
+func (a *Service) idleBots(n int) ([]*bot, error) {
+	a.botMutex.Lock()
+	defer a.botMutex.Unlock()
+	res := make([]*bot, 0, n)
+	for _, b := range a.bots {
+		if len(res) == n {
+			break
+		}
+		if !b.busy {
+			res = append(res, b)
+		}
+	}
+	for len(res) < n {
+		strategy := a.botStrategies[len(a.bots)%len(a.botStrategies)]
+		nickName := fmt.Sprintf("%s%s-%d", botPrefix, strategy.Name(), len(a.bots)+1)
+		id, err := a.repo.Register(nickName)
+		if err != nil {
+			return nil, err
+		}
+		p, err := a.repo.GetPlayerById(id)
+		if err != nil {
+			return nil, err
+		}
+		// p is shared with the repo, update a copy instead.
+		registered := *p
+		registered.Bot = true
+		registered.Trophies = a.rating.Initial()
+		if err := a.repo.Update(&registered); err != nil {
+			return nil, err
+		}
+		b := &bot{player: &registered, strategy: strategy}
+		a.bots = append(a.bots, b)
+		res = append(res, b)
+	}
+	for _, b := range res {
+		b.busy = true
+	}
+	return res, nil
+}
+
+// releaseBots returns the bots of a room to the pool, telling their strategies
+// the secret if observe is set.
+func (a *Service) releaseBots(room *model.Room, observe bool) {
+	a.botMutex.Lock()
+	defer a.botMutex.Unlock()
+	for _, b := range a.bots {
+		for _, p := range room.Players {
+			if p.ID == b.player.ID {
+				if observe {
+					b.strategy.Observe(room.Secret)
+				}
+				b.busy = false
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"regexp"
+	"rooms/dto"
+	"rooms/global"
+	"rooms/model"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WithChat sets the longest chat message in characters, the number of messages
+// kept per room and the words masked in messages.
+func WithChat(maxLength, backlog int, filter []string) func(*Service) {
+	return func(s *Service) {
+		s.chatMaxLength = maxLength
+		s.chatBacklog = max(backlog, 0)
+		s.chatFilter = wordFilter(filter)
+	}
+}
+
+// wordFilter matches any of the words case-insensitively, longer words first.
+// Go's \b only knows ASCII word boundaries, so maskWords checks the boundaries.
+func wordFilter(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	for _, w := range words {
+		if w = strings.TrimSpace(w); len(w) > 0 {
+			quoted = append(quoted, regexp.QuoteMeta(w))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	sort.SliceStable(quoted, func(i, j int) bool {
+		return len(quoted[i]) > len(quoted[j])
+	})
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(quoted, "|") + `)`)
+}
+
+// maskWords replaces the matches of filter that are whole words, not preceded
+// or followed by a letter or a digit of any script, with asterisks.
+func maskWords(filter *regexp.Regexp, message string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range filter.FindAllStringIndex(message, -1) {
+		before, _ := utf8.DecodeLastRuneInString(message[:m[0]])
+		after, _ := utf8.DecodeRuneInString(message[m[1]:])
+		if wordRune(before) || wordRune(after) {
+			continue
+		}
+		b.WriteString(message[last:m[0]])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(message[m[0]:m[1]])))
+		last = m[1]
+	}
+	b.WriteString(message[last:])
+	return b.String()
+}
+
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (a *Service) ChatMaxLength() int {
+	return a.chatMaxLength
+}
+
+// Chat keeps a message of a player to the other players of its room in the
+// room's backlog. Filtered words are masked with asterisks.
+func (a *Service) Chat(id, roomId, message string) (model.ChatMessage, error) {
+	p, err := a.roomPlayer(id, roomId)
+	if err != nil {
+		return model.ChatMessage{}, err
+	}
+	if a.Closed(roomId) {
+		return model.ChatMessage{}, global.ErrNotFound.WithMessage("room is closed")
+	}
+	message = strings.TrimSpace(message)
+	if len(message) == 0 {
+		return model.ChatMessage{}, global.ErrInvalidParams.WithDetails([]dto.FieldError{{Field: "message", Rule: "required"}})
+	}
+	if utf8.RuneCountInString(message) > a.chatMaxLength {
+		return model.ChatMessage{}, global.ErrInvalidParams.WithDetails([]dto.FieldError{{Field: "message", Rule: "max"}})
+	}
+	if a.chatFilter != nil {
+		message = maskWords(a.chatFilter, message)
+	}
+	m := model.ChatMessage{
+		RoomID:   roomId,
+		PlayerID: id,
+		NickName: p.NickName,
+		Message:  message,
+		Time:     time.Now(),
+	}
+	return m, a.repo.AppendChat(m, a.chatBacklog)
+}
+
+// ChatBacklog returns the last chat messages of the player's room.
+func (a *Service) ChatBacklog(id, roomId string) ([]model.ChatMessage, error) {
+	if _, err := a.roomPlayer(id, roomId); err != nil {
+		return nil, err
+	}
+	return a.repo.GetChat(roomId), nil
+}
+
+// roomPlayer returns the player if it is an unbanned player of the room.
+func (a *Service) roomPlayer(id, roomId string) (*model.Player, error) {
+	p, err := a.repo.GetPlayerById(id)
+	if err != nil {
+		return nil, global.ErrNotRegistered.Wrap(err)
+	}
+	if a.banned(p) {
+		return nil, global.ErrBanned
+	}
+	if p.Bot {
+		return nil, errBotPlayer
+	}
+	r, err := a.repo.GetRoomById(roomId)
+	if err != nil {
+		return nil, err
+	}
+	for _, player := range r.Players {
+		if player.ID == id {
+			return p, nil
+		}
+	}
+	return nil, global.ErrNotInRoom
+}
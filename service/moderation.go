@@ -0,0 +1,123 @@
+package service
+
+import (
+	"rooms/global"
+	"rooms/model"
+	"sort"
+	"time"
+)
+
+// Ban keeps a player id or a nickname from registering, joining and guessing.
+// Banned players are taken off the waiting list.
+func (a *Service) Ban(kind, value, reason string) (model.Ban, error) {
+	if kind != global.BanPlayer && kind != global.BanNickname {
+		return model.Ban{}, global.ErrInvalidParams.WithMessage("ban kind must be player or nickname")
+	}
+	b := model.Ban{
+		Kind:      kind,
+		Value:     value,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if err := a.repo.Ban(b); err != nil {
+		return model.Ban{}, err
+	}
+	a.matchMutex.Lock()
+	defer a.matchMutex.Unlock()
+	for _, p := range a.waitingPlayers() {
+		if a.banned(p) {
+			a.repo.RemoveFromWaitingList(p.ID)
+		}
+	}
+	a.logger.Info("ban added", "kind", kind, "value", value, "reason", reason)
+	return b, nil
+}
+
+func (a *Service) Unban(kind, value string) error {
+	if err := a.repo.Unban(kind, value); err != nil {
+		return err
+	}
+	a.logger.Info("ban removed", "kind", kind, "value", value)
+	return nil
+}
+
+// Bans returns the bans, oldest first.
+func (a *Service) Bans() []model.Ban {
+	bans := a.repo.GetBans()
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].CreatedAt.Before(bans[j].CreatedAt)
+	})
+	return bans
+}
+
+// Banned reports whether the player is banned by id or by nickname.
+func (a *Service) Banned(id string) bool {
+	p, err := a.repo.GetPlayerById(id)
+	if err != nil {
+		_, ok := a.repo.GetBan(global.BanPlayer, id)
+		return ok
+	}
+	return a.banned(p)
+}
+
+func (a *Service) banned(p *model.Player) bool {
+	if _, ok := a.repo.GetBan(global.BanPlayer, p.ID); ok {
+		return true
+	}
+	_, ok := a.repo.GetBan(global.BanNickname, p.NickName)
+	return ok
+}
+
+// ClearWaitingList takes every player off the waiting list and returns their
+// ids.
+func (a *Service) ClearWaitingList() []string {
+	a.matchMutex.Lock()
+	defer a.matchMutex.Unlock()
+	ids := make([]string, 0)
+	for _, p := range a.waitingPlayers() {
+		a.repo.RemoveFromWaitingList(p.ID)
+		ids = append(ids, p.ID)
+	}
+	a.logger.Info("waiting list cleared", "players", len(ids))
+	return ids
+}
+
+// CloseRoom ends the game of a room without ranking it; no trophies are won or
+// lost. The room's bots return to the pool.
+func (a *Service) CloseRoom(roomId, reason string) (*model.Room, error) {
+	a.gameOverMutex.Lock()
+	defer a.gameOverMutex.Unlock()
+	room, err := a.repo.GetRoomById(roomId)
+	if err != nil {
+		return nil, err
+	}
+	if a.isFinished(roomId) {
+		return nil, global.ErrInvalidRequest.WithMessage("room is not running")
+	}
+	err = a.repo.AppendEvent(model.RoomEvent{
+		RoomID: roomId,
+		Type:   global.RoomClosed,
+		Time:   time.Now(),
+		Reason: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.releaseBots(room, false)
+	a.logger.Info("room closed", "room", roomId, "reason", reason)
+	return room, nil
+}
+
+// Closed reports whether the room was closed by an operator.
+func (a *Service) Closed(roomId string) bool {
+	for _, e := range a.repo.GetRoomEvents(roomId) {
+		if e.Type == global.RoomClosed {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Service) waitingPlayers() []*model.Player {
+	return a.repo.GetWaitingListSnapshot()
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"math"
+	"rooms/global"
+)
+
+// RatingSystem computes the trophy change of every player of a finished game.
+// Ratings are given ordered by rank, winner first, with the rank of every
+// player; tied players share a rank. The returned deltas follow the same order.
+type RatingSystem interface {
+	Name() string
+	Initial() int
+	Deltas(ratings, ranks []int) []int
+}
+
+func NewRatingSystem(name string, eloK int) RatingSystem {
+	switch name {
+	case "elo":
+		return Elo{K: eloK}
+	default:
+		return FixedPrize{}
+	}
+}
+
+type FixedPrize struct{}
+
+func (FixedPrize) Name() string {
+	return "fixed"
+}
+
+func (FixedPrize) Initial() int {
+	return 0
+}
+
+// Deltas gives the prize of their rank to tied players.
+func (FixedPrize) Deltas(ratings, ranks []int) []int {
+	prizes := []int{global.WinnerPrize, global.SecondPrize}
+	deltas := make([]int, len(ratings))
+	for i := range deltas {
+		deltas[i] = global.Loser
+		if r := ranks[i] - 1; r < len(prizes) {
+			deltas[i] = prizes[r]
+		}
+	}
+	return deltas
+}
+
+// Elo is a multi-player Elo where every game is scored as a set of pairwise
+// matches between all players of the room. Tied players draw.
+type Elo struct {
+	K int
+}
+
+func (Elo) Name() string {
+	return "elo"
+}
+
+func (Elo) Initial() int {
+	return 1000
+}
+
+func (e Elo) Deltas(ratings, ranks []int) []int {
+	deltas := make([]int, len(ratings))
+	if len(ratings) < 2 {
+		return deltas
+	}
+	for i := range ratings {
+		sum := 0.0
+		for j := range ratings {
+			if i == j {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, float64(ratings[j]-ratings[i])/400))
+			actual := 0.0
+			switch {
+			case ranks[i] < ranks[j]:
+				actual = 1
+			case ranks[i] == ranks[j]:
+				actual = 0.5
+			}
+			sum += actual - expected
+		}
+		deltas[i] = int(math.Round(float64(e.K) * sum / float64(len(ratings)-1)))
+	}
+	return deltas
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"rooms/global"
+	"rooms/model"
+	"testing"
+)
+
+func TestNewRatingSystem(t *testing.T) {
+	Convey("Rating system selection", t, func(c C) {
+		for _, tc := range []struct {
+			name string
+			want RatingSystem
+		}{
+			{"elo", Elo{K: 24}},
+			{"fixed", FixedPrize{}},
+			{"", FixedPrize{}},
+			{"unknown", FixedPrize{}},
+		} {
+			c.So(NewRatingSystem(tc.name, 24), ShouldResemble, tc.want)
+		}
+		c.So(Elo{}.Initial(), ShouldEqual, 1000)
+		c.So(FixedPrize{}.Initial(), ShouldEqual, 0)
+	})
+}
+
+func TestDeltas(t *testing.T) {
+	Convey("Deltas", t, func(c C) {
+		for _, tc := range []struct {
+			name    string
+			rating  RatingSystem
+			ratings []int
+			ranks   []int
+			want    []int
+		}{
+			{"fixed prizes", FixedPrize{}, []int{0, 0, 0}, []int{1, 2, 3}, []int{global.WinnerPrize, global.SecondPrize, global.Loser}},
+			{"fixed tie for first", FixedPrize{}, []int{0, 0, 0}, []int{1, 1, 3}, []int{global.WinnerPrize, global.WinnerPrize, global.Loser}},
+			{"fixed tie for second", FixedPrize{}, []int{0, 0, 0}, []int{1, 2, 2}, []int{global.WinnerPrize, global.SecondPrize, global.SecondPrize}},
+			{"fixed single player", FixedPrize{}, []int{0}, []int{1}, []int{global.WinnerPrize}},
+			{"elo equal ratings", Elo{K: 32}, []int{1000, 1000, 1000}, []int{1, 2, 3}, []int{16, 0, -16}},
+			{"elo tie for first", Elo{K: 32}, []int{1000, 1000, 1000}, []int{1, 1, 3}, []int{8, 8, -16}},
+			{"elo all tied", Elo{K: 32}, []int{1000, 1000, 1000}, []int{1, 1, 1}, []int{0, 0, 0}},
+			{"elo tie between unequal ratings", Elo{K: 32}, []int{1200, 1000}, []int{1, 1}, []int{-8, 8}},
+			{"elo favourite wins", Elo{K: 32}, []int{1200, 1000}, []int{1, 2}, []int{8, -8}},
+			{"elo underdog wins", Elo{K: 32}, []int{1000, 1200}, []int{1, 2}, []int{24, -24}},
+			{"elo single player", Elo{K: 32}, []int{1000}, []int{1}, []int{0}},
+		} {
+			Convey(tc.name, func(c C) {
+				c.So(tc.rating.Deltas(tc.ratings, tc.ranks), ShouldResemble, tc.want)
+			})
+		}
+	})
+}
+
+func TestRank(t *testing.T) {
+	Convey("Rank", t, func(c C) {
+		a := NewService(WithRatingSystem(Elo{K: 32}))
+		players := []*model.Player{
+			{ID: "none1", Guess: -1, Trophies: 1000},
+			{ID: "far", Guess: 9, Diff: 6, Trophies: 1000},
+			{ID: "near1", Guess: 1, Diff: 2, Trophies: 1000},
+			{ID: "none2", Guess: -1, Trophies: 1000},
+			{ID: "near2", Guess: 5, Diff: 2, Trophies: 1000},
+		}
+		a.rank(players)
+		ids := make([]string, 0, len(players))
+		for _, p := range players {
+			ids = append(ids, p.ID)
+		}
+		c.So(ids, ShouldResemble, []string{"near1", "near2", "far", "none1", "none2"})
+		for _, tc := range []struct {
+			rank, score int
+		}{{1, 12}, {1, 12}, {3, 0}, {5, -12}, {5, -12}} {
+			p := players[0]
+			players = players[1:]
+			c.So(p.Rank, ShouldEqual, tc.rank)
+			c.So(p.Score, ShouldEqual, tc.score)
+			c.So(p.Trophies, ShouldEqual, 1000+tc.score)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"rooms/global"
+	"rooms/model"
+	"sort"
+	"time"
+)
+
+// Backoff of RunSeasons after a season could not be read or ended.
+const (
+	seasonRetryMin = time.Second
+	seasonRetryMax = 10 * time.Minute
+)
+
+func (a *Service) CurrentSeason() (model.Season, error) {
+	seasons := a.repo.GetSeasons()
+	if n := len(seasons); n > 0 && seasons[n-1].End.IsZero() {
+		return seasons[n-1], nil
+	}
+	return a.repo.StartSeason(time.Now())
+}
+
+func (a *Service) Seasons() []model.Season {
+	return a.repo.GetSeasons()
+}
+
+func (a *Service) SeasonLeaderboard(id int) ([]model.LeaderboardEntry, error) {
+	season, err := a.repo.GetSeasonById(id)
+	if err != nil {
+		return nil, err
+	}
+	if season.End.IsZero() {
+		return a.Leaderboard(), nil
+	}
+	return season.Leaderboard, nil
+}
+
+func (a *Service) Leaderboard() []model.LeaderboardEntry {
+	players := a.repo.GetPlayersSnapshot()
+	res := make([]model.LeaderboardEntry, 0, len(players))
+	for _, p := range players {
+		if p.Bot {
+			continue
+		}
+		res = append(res, model.LeaderboardEntry{
+			PlayerID: p.ID,
+			NickName: p.NickName,
+			Trophies: p.Trophies,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Trophies != res[j].Trophies {
+			return res[i].Trophies > res[j].Trophies
+		}
+		return res[i].NickName < res[j].NickName
+	})
+	for i := range res {
+		res[i].Rank = i + 1
+	}
+	return res
+}
+
+// EndSeason archives the leaderboard of the running season, softly resets the
+// trophies of every player towards the initial rating and starts the next season.
+func (a *Service) EndSeason() (model.Season, error) {
+	current, err := a.CurrentSeason()
+	if err != nil {
+		return model.Season{}, err
+	}
+	// Games finishing meanwhile would change trophies between the archive and
+	// the reset, or lose their trophies to it.
+	a.gameOverMutex.Lock()
+	defer a.gameOverMutex.Unlock()
+	err = a.repo.CloseSeason(current.ID, time.Now(), a.Leaderboard())
+	if err != nil {
+		return model.Season{}, err
+	}
+	initial := a.rating.Initial()
+	for _, p := range a.repo.GetPlayersSnapshot() {
+		p.Trophies = initial + (p.Trophies-initial)*global.SeasonTrophyKeep/100
+		a.repo.Update(p)
+	}
+	return a.repo.StartSeason(time.Now())
+}
+
+// RunSeasons ends the running season once it is length old, until ctx is
+// done. A season that could not be read or ended is retried with a growing
+// backoff.
+func (a *Service) RunSeasons(ctx context.Context, length time.Duration) {
+	var retry time.Duration
+	for {
+		current, err := a.CurrentSeason()
+		wait := time.Until(current.Start.Add(length))
+		if err != nil {
+			retry = min(max(2*retry, seasonRetryMin), seasonRetryMax)
+			wait = retry
+			a.logger.Error("Could not get current season", "retryIn", retry, "err", err)
+		}
+		timer := time.NewTimer(max(wait, retry))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			if err != nil {
+				continue
+			}
+			next, err := a.EndSeason()
+			if err != nil {
+				retry = min(max(2*retry, seasonRetryMin), seasonRetryMax)
+				a.logger.Error("Could not end season", "season", current.ID, "retryIn", retry, "err", err)
+				continue
+			}
+			retry = 0
+			a.logger.Info("season ended", "season", current.ID, "next", next.ID)
+		}
+	}
+}
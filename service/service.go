@@ -1,22 +1,44 @@
 package service
 
 import (
-	"fmt"
+	"context"
 	"github.com/google/uuid"
+	"log/slog"
 	"math/rand"
+	"regexp"
 	"rooms/global"
+	"rooms/metrics"
 	"rooms/model"
 	"rooms/repo"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Service struct {
-	repo repo.Repo
+	repo          repo.Repo
+	rating        RatingSystem
+	logger        *slog.Logger
+	gameOverMutex sync.Mutex
+	matchMutex    sync.Mutex
+	lastMatch     atomic.Int64
+	botWait       time.Duration
+	botStrategies []BotStrategy
+	botMutex      sync.Mutex
+	bots          []*bot
+	chatMaxLength int
+	chatBacklog   int
+	chatFilter    *regexp.Regexp
 }
 
 func NewService(options ...func(*Service)) *Service {
-	as := &Service{}
+	as := &Service{
+		rating:        FixedPrize{},
+		logger:        slog.Default(),
+		chatMaxLength: 200,
+		chatBacklog:   50,
+	}
 	for _, o := range options {
 		o(as)
 	}
@@ -29,18 +51,55 @@ func WithRepo(r repo.Repo) func(*Service) {
 	}
 }
 
+func WithLogger(l *slog.Logger) func(*Service) {
+	return func(s *Service) {
+		s.logger = l
+	}
+}
+
+func WithRatingSystem(r RatingSystem) func(*Service) {
+	return func(s *Service) {
+		s.rating = r
+	}
+}
+
 func (a *Service) Register(nickName string) (string, error) {
+	if err := checkNickName(nickName); err != nil {
+		return "", err
+	}
+	if _, ok := a.repo.GetBan(global.BanNickname, nickName); ok {
+		return "", global.ErrBanned
+	}
 	existedPlayer, err := a.repo.GetPlayerByNickName(nickName)
 	if err == nil {
+		if a.banned(existedPlayer) {
+			return "", global.ErrBanned
+		}
 		return existedPlayer.ID, nil
 	}
 
-	return a.repo.Register(nickName)
+	id, err := a.repo.Register(nickName)
+	if err != nil {
+		return "", err
+	}
+	metrics.Registrations.Inc()
+	p, err := a.repo.GetPlayerById(id)
+	if err != nil {
+		return "", err
+	}
+	// The player is already readable by others, so a copy is updated.
+	registered := *p
+	registered.Trophies = a.rating.Initial()
+	return id, a.repo.Update(&registered)
 }
 
 func (a *Service) Stats() (model.Stats, error) {
 	res := model.Stats{}
-	res.RegisteredPlayers = len(a.repo.GetAllPlayers())
+	for _, p := range a.repo.GetPlayersSnapshot() {
+		if !p.Bot {
+			res.RegisteredPlayers++
+		}
+	}
 	res.ActiveRooms = a.repo.GetAllRooms()
 	return res, nil
 }
@@ -48,8 +107,15 @@ func (a *Service) Stats() (model.Stats, error) {
 func (a *Service) Join(id string) error {
 	p, err := a.repo.GetPlayerById(id)
 	if err != nil {
-		return fmt.Errorf("%s", global.NotRegistered)
+		return global.ErrNotRegistered.Wrap(err)
+	}
+	if a.banned(p) {
+		return global.ErrBanned
+	}
+	if p.Bot {
+		return errBotPlayer
 	}
+	p.JoinedAt = time.Now()
 	err = a.repo.Join(p)
 	if err != nil {
 		return err
@@ -57,14 +123,42 @@ func (a *Service) Join(id string) error {
 	return nil
 }
 
-func (a *Service) Guess(id string, roomId string, guess int) error {
+func (a *Service) Leave(id string) error {
 	_, err := a.repo.GetPlayerById(id)
 	if err != nil {
-		return fmt.Errorf("%s", global.NotRegistered)
+		return global.ErrNotRegistered.Wrap(err)
+	}
+	return a.repo.RemoveFromWaitingList(id)
+}
+
+func (a *Service) Guess(id string, roomId string, guess int) error {
+	player, err := a.repo.GetPlayerById(id)
+	if err != nil {
+		return global.ErrNotRegistered.Wrap(err)
+	}
+	if a.banned(player) {
+		return global.ErrBanned
+	}
+	if player.Bot {
+		return errBotPlayer
 	}
+	return a.guess(id, roomId, guess)
+}
+
+// guess records the guess of a player, bots included.
+func (a *Service) guess(id string, roomId string, guess int) error {
 	r, err := a.repo.GetRoomById(roomId)
 	if err != nil {
-		return fmt.Errorf("%s", global.NotFoundErr)
+		return err
+	}
+	if a.Closed(roomId) {
+		return global.ErrNotFound.WithMessage("room is closed")
+	}
+	// A late guess would be journaled after the result and break the replay.
+	a.gameOverMutex.Lock()
+	defer a.gameOverMutex.Unlock()
+	if a.isFinished(roomId) {
+		return global.ErrInvalidRequest.WithMessage("game is over")
 	}
 	p := &model.Player{}
 	for _, player := range r.Players {
@@ -74,38 +168,187 @@ func (a *Service) Guess(id string, roomId string, guess int) error {
 		}
 	}
 	if len(p.ID) == 0 {
-		return fmt.Errorf("%s", global.NotInRoom)
+		return global.ErrNotInRoom
 	}
 	p.Guess = guess
 	p.Diff = abs(r.Secret - guess)
+	// Bots guess as soon as the room is created, which says nothing about
+	// how long players take.
+	if !r.CreatedAt.IsZero() && !p.Bot {
+		metrics.GuessLatency.Observe(time.Since(r.CreatedAt).Seconds())
+	}
 	err = a.repo.Update(p)
 	if err != nil {
 		return err
 	}
-	return nil
+	return a.repo.AppendEvent(model.RoomEvent{
+		RoomID:   roomId,
+		Type:     global.GuessReceived,
+		Time:     time.Now(),
+		PlayerID: id,
+		Guess:    guess,
+	})
 }
 
 func (a *Service) CreateRooms() map[string]*model.Room {
+	a.matchMutex.Lock()
+	defer a.matchMutex.Unlock()
 	rand.Seed(time.Now().UnixNano())
-	waitingList := a.repo.GetWaitingList()
-	p := make([]*model.Player, 0)
-	for _, player := range waitingList {
-		p = append(p, player)
-	}
-	for i := 0; i < len(waitingList)-2; i = i + 3 {
-		a.repo.CreateRoom(&model.Room{
-			ID:      uuid.New().String(),
-			Players: p[i : i+3],
-			Secret:  rand.Intn(10) + 1,
-		})
-		a.repo.RemoveFromWaitingList(p[i].ID)
-		a.repo.RemoveFromWaitingList(p[i+1].ID)
-		a.repo.RemoveFromWaitingList(p[i+2].ID)
+	p := a.repo.GetWaitingListSnapshot()
+	// Longest waiting players first, so that bots fill the newest room.
+	sort.SliceStable(p, func(i, j int) bool {
+		return p[i].JoinedAt.Before(p[j].JoinedAt)
+	})
+	i := 0
+	for ; i+global.RoomSize <= len(p); i = i + global.RoomSize {
+		a.createRoom(p[i:i+global.RoomSize], nil)
+	}
+	if rest := p[i:]; len(rest) > 0 && a.botsEnabled() && a.waitedFor(rest) >= a.botWait {
+		a.fillWithBots(rest)
 	}
+	a.lastMatch.Store(time.Now().UnixNano())
 
 	return a.repo.GetAllRooms()
 }
 
+// createRoom places players into a new room and takes them off the waiting
+// list. bots are the ids of the bot players among them.
+func (a *Service) createRoom(players []*model.Player, bots []string) *model.Room {
+	room := &model.Room{
+		ID:        uuid.New().String(),
+		Players:   players,
+		Secret:    rand.Intn(global.SecretMax-global.SecretMin+1) + global.SecretMin,
+		CreatedAt: time.Now(),
+	}
+	ids := make([]string, 0, global.RoomSize)
+	trophies := make([]int, 0, global.RoomSize)
+	for _, player := range room.Players {
+		if !player.JoinedAt.IsZero() {
+			metrics.MatchmakingWait.Observe(room.CreatedAt.Sub(player.JoinedAt).Seconds())
+		}
+		player.Guess = -1
+		player.Diff = 0
+		player.Rank = 0
+		ids = append(ids, player.ID)
+		trophies = append(trophies, player.Trophies)
+	}
+	a.repo.CreateRoom(room)
+	a.logger.Info("room created", "room", room.ID, "players", ids)
+	a.repo.AppendEvent(model.RoomEvent{
+		RoomID:   room.ID,
+		Type:     global.RoomCreated,
+		Time:     room.CreatedAt,
+		Players:  ids,
+		Bots:     bots,
+		Trophies: trophies,
+		Secret:   room.Secret,
+	})
+	for _, id := range ids {
+		a.repo.RemoveFromWaitingList(id)
+	}
+	return room
+}
+
+// fillWithBots puts the waiting players into a room together with bots, which
+// guess right away.
+func (a *Service) fillWithBots(players []*model.Player) {
+	bots, err := a.idleBots(global.RoomSize - len(players))
+	if err != nil {
+		a.logger.Error("Could not get bots", "err", err)
+		return
+	}
+	players = players[:len(players):len(players)]
+	ids := make([]string, 0, len(bots))
+	for _, b := range bots {
+		players = append(players, b.player)
+		ids = append(ids, b.player.ID)
+	}
+	room := a.createRoom(players, ids)
+	for _, b := range bots {
+		guess := b.strategy.Guess(global.SecretMin, global.SecretMax)
+		if err := a.guess(b.player.ID, room.ID, guess); err != nil {
+			a.logger.Error("Bot could not guess", "room", room.ID, "player", b.player.ID, "err", err)
+		}
+	}
+}
+
+// waitedFor is how long the longest waiting of the players has waited.
+func (a *Service) waitedFor(players []*model.Player) time.Duration {
+	var res time.Duration
+	for _, p := range players {
+		if d := time.Since(p.JoinedAt); !p.JoinedAt.IsZero() && d > res {
+			res = d
+		}
+	}
+	return res
+}
+
+// RunMatchmaker creates rooms from the waiting list every interval until ctx is done.
+func (a *Service) RunMatchmaker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	a.CreateRooms()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.CreateRooms()
+		}
+	}
+}
+
+func (a *Service) LastMatchmaking() time.Time {
+	last := a.lastMatch.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// PlayerRoom returns the newest unfinished room the player was placed into
+// since it last joined. Finished rooms are kept, so a player who played before
+// is in several rooms.
+func (a *Service) PlayerRoom(id string) (*model.Room, bool) {
+	var res *model.Room
+	for _, room := range a.RunningRooms() {
+		if res != nil && !room.CreatedAt.After(res.CreatedAt) {
+			continue
+		}
+		for _, p := range room.Players {
+			if p.ID == id && !room.CreatedAt.Before(p.JoinedAt) {
+				res = room
+				break
+			}
+		}
+	}
+	return res, res != nil
+}
+
+// RunningRooms returns the rooms whose game has neither finished nor been
+// closed.
+func (a *Service) RunningRooms() []*model.Room {
+	res := make([]*model.Room, 0)
+	for _, room := range a.repo.GetRoomsSnapshot() {
+		if !a.isFinished(room.ID) {
+			res = append(res, room)
+		}
+	}
+	return res
+}
+
+func (a *Service) Room(roomId string) (*model.Room, error) {
+	return a.repo.GetRoomById(roomId)
+}
+
+func (a *Service) RatingSystem() RatingSystem {
+	return a.rating
+}
+
+func (a *Service) Ping() error {
+	return a.repo.Ping()
+}
+
 func (a *Service) GetGameResults(roomId string) model.GameResult {
 	res := model.GameResult{}
 	for _, r := range a.repo.GetAllRooms() {
@@ -125,22 +368,146 @@ func (a *Service) GetGameResults(roomId string) model.GameResult {
 	}
 	return res
 }
+
 func (a *Service) GameOver(roomId string) {
+	a.gameOverMutex.Lock()
+	defer a.gameOverMutex.Unlock()
+	a.finish(roomId)
+}
+
+// ForceFinish ends the game of a room even if not every player has guessed yet.
+// Players without a guess are journaled as timed out and ranked last.
+func (a *Service) ForceFinish(roomId string) (model.GameResult, error) {
+	a.gameOverMutex.Lock()
+	defer a.gameOverMutex.Unlock()
+	room, err := a.repo.GetRoomById(roomId)
+	if err != nil {
+		return model.GameResult{}, err
+	}
+	if !a.isFinished(roomId) {
+		for _, p := range room.Players {
+			if p.Guess == -1 {
+				a.repo.AppendEvent(model.RoomEvent{
+					RoomID:   roomId,
+					Type:     global.GuessTimeout,
+					Time:     time.Now(),
+					PlayerID: p.ID,
+				})
+			}
+		}
+		a.finish(roomId)
+	}
+	return a.GetGameResults(roomId), nil
+}
+
+func (a *Service) Finished(roomId string) bool {
+	return a.isFinished(roomId)
+}
+
+func (a *Service) finish(roomId string) {
+	if a.isFinished(roomId) {
+		return
+	}
 	rooms := a.repo.GetAllRooms()
 	for _, room := range rooms {
 		if room.ID == roomId {
-			sort.Sort(sortByDiff(room.Players))
-			room.Players[0].Score = global.WinnerPrize
-			room.Players[0].Rank = 1
-			room.Players[1].Score = global.SecondPrize
-			room.Players[1].Rank = 2
-			room.Players[2].Score = global.Loser
-			room.Players[2].Rank = 3
+			a.rank(room.Players)
+			a.releaseBots(room, true)
+			res := a.GetGameResults(roomId)
+			a.repo.AppendEvent(model.RoomEvent{
+				RoomID:   roomId,
+				Type:     global.GameFinished,
+				Time:     time.Now(),
+				Secret:   res.Secret,
+				Rankings: res.Rankings,
+			})
+			metrics.GamesFinished.Inc()
+			a.logger.Info("game finished", "room", roomId, "secret", res.Secret)
 			break
 		}
 	}
 }
 
+func (a *Service) Events(roomId string) ([]model.RoomEvent, error) {
+	_, err := a.repo.GetRoomById(roomId)
+	if err != nil {
+		return nil, err
+	}
+	return a.repo.GetRoomEvents(roomId), nil
+}
+
+// Replay re-derives the game result of a room from its journal and checks it
+// against the gameOver event that was sent to the players. Events after the
+// gameOver event are not part of the game.
+func (a *Service) Replay(roomId string) (model.GameResult, error) {
+	events, err := a.Events(roomId)
+	if err != nil {
+		return model.GameResult{}, err
+	}
+	var players []*model.Player
+	var sent *model.RoomEvent
+	res := model.GameResult{}
+	for i, e := range events {
+		if sent != nil {
+			break
+		}
+		switch e.Type {
+		case global.RoomCreated:
+			res.Secret = e.Secret
+			for j, id := range e.Players {
+				p := &model.Player{ID: id, Guess: -1}
+				if j < len(e.Trophies) {
+					p.Trophies = e.Trophies[j]
+				}
+				players = append(players, p)
+			}
+		case global.GuessReceived:
+			for _, p := range players {
+				if p.ID == e.PlayerID {
+					p.Guess = e.Guess
+					p.Diff = abs(res.Secret - e.Guess)
+				}
+			}
+		case global.GameFinished:
+			sent = &events[i]
+		}
+	}
+	if players == nil || sent == nil {
+		return res, global.ErrNotFound.WithMessage("room has no finished game to replay")
+	}
+	a.rank(players)
+	for _, p := range players {
+		res.Rankings = append(res.Rankings, model.Ranking{
+			Player:      *p,
+			Rank:        p.Rank,
+			DeltaTrophy: p.Score,
+		})
+	}
+	if len(sent.Rankings) != len(res.Rankings) {
+		return res, global.ErrReplayMismatch.WithMessage("replayed rankings differ from sent rankings")
+	}
+	for i, r := range res.Rankings {
+		s := sent.Rankings[i]
+		if s.Player.ID != r.Player.ID || s.Player.Guess != r.Player.Guess || s.Rank != r.Rank || s.DeltaTrophy != r.DeltaTrophy {
+			return res, global.ErrReplayMismatch.WithMessage("replayed rankings differ from sent rankings")
+		}
+	}
+	if sent.Secret != res.Secret {
+		return res, global.ErrReplayMismatch.WithMessage("replayed secret differs from sent secret")
+	}
+	return res, nil
+}
+
+// isFinished reports whether the game of the room ended or the room was closed.
+func (a *Service) isFinished(roomId string) bool {
+	for _, e := range a.repo.GetRoomEvents(roomId) {
+		if e.Type == global.GameFinished || e.Type == global.RoomClosed {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Service) AllGuessDone(roomId string) bool {
 	room, _ := a.repo.GetRoomById(roomId)
 	for _, player := range room.Players {
@@ -151,18 +518,34 @@ func (a *Service) AllGuessDone(roomId string) bool {
 	return true
 }
 
-type sortByDiff []*model.Player
-
-func (e sortByDiff) Len() int {
-	return len(e)
-}
-
-func (e sortByDiff) Less(i, j int) bool {
-	return e[i].Diff < e[j].Diff
-}
-
-func (e sortByDiff) Swap(i, j int) {
-	e[i], e[j] = e[j], e[i]
+func (a *Service) rank(players []*model.Player) {
+	// Stable, so that a replay of the journal ranks tied players the same way.
+	sort.SliceStable(players, func(i, j int) bool {
+		if (players[i].Guess == -1) != (players[j].Guess == -1) {
+			return players[j].Guess == -1
+		}
+		return players[i].Diff < players[j].Diff
+	})
+	ratings := make([]int, 0, len(players))
+	ranks := make([]int, 0, len(players))
+	for i, p := range players {
+		switch {
+		case p.Guess == -1:
+			// Players without a guess share the last place, so that not
+			// guessing never beats a guess.
+			p.Rank = len(players)
+		case i > 0 && players[i-1].Diff == p.Diff:
+			p.Rank = players[i-1].Rank
+		default:
+			p.Rank = i + 1
+		}
+		ratings = append(ratings, p.Trophies)
+		ranks = append(ranks, p.Rank)
+	}
+	for i, delta := range a.rating.Deltas(ratings, ranks) {
+		players[i].Score = delta
+		players[i].Trophies += delta
+	}
 }
 
 func abs(x int) int {